@@ -5,42 +5,43 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 
 	"github.com/athenianco/cloud-common/dbs"
-)
-
-const (
-	pgConnMaxLifetime = time.Minute
-	pgConnMaxIdleTime = 30 * time.Second
+	"github.com/athenianco/cloud-common/dbs/pg"
 )
 
 // OpenDatabaseFromEnv opens default postgres database based on environment variable:
 // STATE_DATABASE_URI
+//
+// It fails if the schema is behind, see Migrate.
 func OpenDatabaseFromEnv() (Database, error) {
 	const dbEnv = "STATE_DATABASE_URI"
 	dbURI := os.Getenv(dbEnv)
 	if dbURI == "" {
 		return nil, errors.New(dbEnv + " is not set")
 	}
-	return Open(context.Background(), processAddress(dbURI))
-}
-
-// Open creates a state database based on Postgres.
-func Open(ctx context.Context, addr string) (Database, error) {
-	config, err := pgxpool.ParseConfig(addr)
+	ctx := context.Background()
+	db, err := open(ctx, dbURI)
 	if err != nil {
 		return nil, err
 	}
-	config.ConnConfig.PreferSimpleProtocol = true
-	config.MaxConnLifetime = pgConnMaxLifetime
-	config.MaxConnIdleTime = pgConnMaxIdleTime
+	if err = migrations.StartupCheck(ctx, db.db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-	conn, err := pgxpool.ConnectConfig(ctx, config)
+// Open creates a state database based on Postgres.
+func Open(ctx context.Context, addr string, opts ...pg.Option) (Database, error) {
+	return open(ctx, addr, opts...)
+}
+
+func open(ctx context.Context, addr string, opts ...pg.Option) (*database, error) {
+	conn, err := pg.Open(ctx, "state", addr, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -48,11 +49,7 @@ func Open(ctx context.Context, addr string) (Database, error) {
 }
 
 type database struct {
-	db *pgxpool.Pool
-}
-
-func processAddress(addr string) string {
-	return strings.Replace(addr, "&binary_parameters=yes", "", -1)
+	db *pg.Pool
 }
 
 func scanAccount(sc dbs.Scanner) (Account, error) {
@@ -73,57 +70,46 @@ func scanAccount(sc dbs.Scanner) (Account, error) {
 	}, err
 }
 
+func scanInt64(sc dbs.Scanner) (int64, error) {
+	var v int64
+	err := sc.Scan(&v)
+	return v, err
+}
+
 const accountColumns = `id, created_at, secret, secret_salt, expires_at`
 
 func (db *database) GetAccount(ctx context.Context, id AccountID) (*Account, error) {
-	row := db.db.QueryRow(ctx, `SELECT `+accountColumns+` FROM public.accounts WHERE id = $1`, int64(id))
-	acc, err := scanAccount(row)
-	if err == pgx.ErrNoRows {
-		return nil, ErrNotFound
-	} else if err != nil {
+	acc, err := dbs.QueryOne(ctx, db.db, scanAccount, `SELECT `+accountColumns+` FROM public.accounts WHERE id = $1`, int64(id))
+	if err != nil {
 		return nil, err
 	}
 	return &acc, nil
 }
 
 func (db *database) GetAccountBySecret(ctx context.Context, secret string) (*Account, error) {
-	row := db.db.QueryRow(ctx, `SELECT `+accountColumns+` FROM public.accounts WHERE secret = $1`, secret)
-	acc, err := scanAccount(row)
-	if err == pgx.ErrNoRows {
-		return nil, ErrNotFound
-	} else if err != nil {
+	acc, err := dbs.QueryOne(ctx, db.db, scanAccount, `SELECT `+accountColumns+` FROM public.accounts WHERE secret = $1`, secret)
+	if err != nil {
 		return nil, err
 	}
 	return &acc, nil
 }
 
 func (db *database) ListAccounts(ctx context.Context) ([]Account, error) {
-	rows, err := db.db.Query(ctx, `SELECT `+accountColumns+` FROM public.accounts`)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []Account
-	for rows.Next() {
-		acc, err := scanAccount(rows)
-		if err != nil {
-			return out, err
-		}
-		out = append(out, acc)
-	}
-	return out, rows.Err()
+	it := dbs.QueryKeyset(ctx, db.db, dbs.Keyset[Account, AccountID]{
+		Query: func(after *AccountID, limit int) (string, []interface{}) {
+			if after == nil {
+				return `SELECT ` + accountColumns + ` FROM public.accounts ORDER BY id LIMIT $1`, []interface{}{limit}
+			}
+			return `SELECT ` + accountColumns + ` FROM public.accounts WHERE id > $1 ORDER BY id LIMIT $2`, []interface{}{int64(*after), limit}
+		},
+		Scan: scanAccount,
+		Key:  func(acc *Account) AccountID { return acc.ID },
+	})
+	return dbs.Collect[Account](it)
 }
 
 func (db *database) getAccountFeatureID(ctx context.Context, feature AccountFeature) (int64, error) {
-	row := db.db.QueryRow(ctx, `SELECT id FROM features WHERE name = $1`, feature)
-	var id int64
-	err := row.Scan(&id)
-	if err == pgx.ErrNoRows {
-		return 0, ErrNotFound
-	} else if err != nil {
-		return 0, err
-	}
-	return id, nil
+	return dbs.QueryOne(ctx, db.db, scanInt64, `SELECT id FROM features WHERE name = $1`, feature)
 }
 
 func (db *database) SetAccountFeature(ctx context.Context, id AccountID, feature AccountFeature, parameters interface{}) error {
@@ -168,59 +154,33 @@ func (db *database) DeleteJiraToAthenian(ctx context.Context, aid AccountID) err
 }
 
 func (db *database) JiraToAthenian(ctx context.Context, id JiraAccountID) (AccountID, error) {
-	var accID int64
-	err := db.db.QueryRow(ctx, `SELECT account_id FROM public.account_jira_installations WHERE id = $1`, int64(id)).Scan(&accID)
-	if err == pgx.ErrNoRows {
-		return 0, ErrNotFound
-	} else if err != nil {
+	accID, err := dbs.QueryOne(ctx, db.db, scanInt64, `SELECT account_id FROM public.account_jira_installations WHERE id = $1`, int64(id))
+	if err != nil {
 		return 0, err
 	}
 	return AccountID(accID), nil
 }
 
 func (db *database) AthenianToJira(ctx context.Context, id AccountID) ([]JiraAccountID, error) {
-	rows, err := db.db.Query(ctx, `SELECT id FROM public.account_jira_installations WHERE account_id = $1`, int64(id))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []JiraAccountID
-	for rows.Next() {
-		var rid int64
-		if err := rows.Scan(&rid); err != nil {
-			return out, err
-		}
-		out = append(out, JiraAccountID(rid))
-	}
-	return out, rows.Err()
+	return dbs.QueryAll(ctx, db.db, func(sc dbs.Scanner) (JiraAccountID, error) {
+		rid, err := scanInt64(sc)
+		return JiraAccountID(rid), err
+	}, `SELECT id FROM public.account_jira_installations WHERE account_id = $1`, int64(id))
 }
 
 func (db *database) GithubToAthenian(ctx context.Context, id GithubAccountID) (AccountID, error) {
-	var accID int64
-	err := db.db.QueryRow(ctx, `SELECT account_id FROM public.account_github_accounts WHERE id = $1`, int64(id)).Scan(&accID)
-	if err == pgx.ErrNoRows {
-		return 0, ErrNotFound
-	} else if err != nil {
+	accID, err := dbs.QueryOne(ctx, db.db, scanInt64, `SELECT account_id FROM public.account_github_accounts WHERE id = $1`, int64(id))
+	if err != nil {
 		return 0, err
 	}
 	return AccountID(accID), nil
 }
 
 func (db *database) AthenianToGithub(ctx context.Context, id AccountID) ([]GithubAccountID, error) {
-	rows, err := db.db.Query(ctx, `SELECT id FROM public.account_github_accounts WHERE account_id = $1`, int64(id))
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var out []GithubAccountID
-	for rows.Next() {
-		var rid int64
-		if err := rows.Scan(&rid); err != nil {
-			return out, err
-		}
-		out = append(out, GithubAccountID(rid))
-	}
-	return out, rows.Err()
+	return dbs.QueryAll(ctx, db.db, func(sc dbs.Scanner) (GithubAccountID, error) {
+		rid, err := scanInt64(sc)
+		return GithubAccountID(rid), err
+	}, `SELECT id FROM public.account_github_accounts WHERE account_id = $1`, int64(id))
 }
 
 func (db *database) Close() error {
@@ -0,0 +1,2 @@
+-- The tables are owned by the API, they are never dropped by this package.
+SELECT 1;
@@ -0,0 +1,39 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    id serial NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    secret text NOT NULL,
+    secret_salt int NOT NULL,
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY(id)
+);
+CREATE UNIQUE INDEX IF NOT EXISTS accounts_secret ON accounts(secret);
+
+CREATE TABLE IF NOT EXISTS features (
+    id serial NOT NULL,
+    name text NOT NULL,
+    PRIMARY KEY(id),
+    UNIQUE(name)
+);
+
+CREATE TABLE IF NOT EXISTS account_features (
+    account_id int NOT NULL REFERENCES accounts(id),
+    feature_id int NOT NULL REFERENCES features(id),
+    enabled bool NOT NULL DEFAULT FALSE,
+    parameters jsonb,
+    updated_at timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY(account_id, feature_id)
+);
+
+CREATE TABLE IF NOT EXISTS account_jira_installations (
+    id bigint NOT NULL,
+    account_id int NOT NULL REFERENCES accounts(id),
+    PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS account_jira_installations_account ON account_jira_installations(account_id);
+
+CREATE TABLE IF NOT EXISTS account_github_accounts (
+    id bigint NOT NULL,
+    account_id int NOT NULL REFERENCES accounts(id),
+    PRIMARY KEY(id)
+);
+CREATE INDEX IF NOT EXISTS account_github_accounts_account ON account_github_accounts(account_id);
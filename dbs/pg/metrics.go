@@ -0,0 +1,124 @@
+package pg
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	labelPool  = "pool"
+	labelState = "state"
+)
+
+var (
+	descConns = prometheus.NewDesc("athenian_db_pool_conns",
+		"The number of connections in the pool by state",
+		[]string{labelPool, labelState}, nil)
+	descMaxConns = prometheus.NewDesc("athenian_db_pool_max_conns",
+		"The max number of connections in the pool",
+		[]string{labelPool}, nil)
+	descAcquires = prometheus.NewDesc("athenian_db_pool_acquire_count",
+		"The count of connections acquired from the pool",
+		[]string{labelPool}, nil)
+	descEmptyAcquires = prometheus.NewDesc("athenian_db_pool_empty_acquire_count",
+		"The count of acquires that waited for a connection because the pool was empty",
+		[]string{labelPool}, nil)
+	descCanceledAcquires = prometheus.NewDesc("athenian_db_pool_canceled_acquire_count",
+		"The count of acquires that were cancelled by the context",
+		[]string{labelPool}, nil)
+	descAcquireSeconds = prometheus.NewDesc("athenian_db_pool_acquire_seconds",
+		"The total time spent acquiring connections",
+		[]string{labelPool}, nil)
+)
+
+var stats = &statsCollector{
+	pools:  make(map[*Pool]struct{}),
+	closed: make(map[string]poolStats),
+}
+
+func init() {
+	prometheus.MustRegister(stats)
+}
+
+// statsCollector exports statistics of open pools. Pools with the same name are summed up.
+// Counters of closed pools are kept, so they never decrease when a pool is closed.
+type statsCollector struct {
+	mu     sync.Mutex
+	pools  map[*Pool]struct{}
+	closed map[string]poolStats // counters of closed pools by name
+}
+
+func (c *statsCollector) add(p *Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pools[p] = struct{}{}
+}
+
+func (c *statsCollector) remove(p *Pool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.pools[p]; !ok {
+		return
+	}
+	delete(c.pools, p)
+	s := p.Stat()
+	st := c.closed[p.name]
+	st.acquires += float64(s.AcquireCount())
+	st.empty += float64(s.EmptyAcquireCount())
+	st.canceled += float64(s.CanceledAcquireCount())
+	st.acquireSeconds += s.AcquireDuration().Seconds()
+	c.closed[p.name] = st
+}
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- descConns
+	ch <- descMaxConns
+	ch <- descAcquires
+	ch <- descEmptyAcquires
+	ch <- descCanceledAcquires
+	ch <- descAcquireSeconds
+}
+
+type poolStats struct {
+	acquired, idle, constructing, max float64
+	acquires, empty, canceled         float64
+	acquireSeconds                    float64
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	byName := make(map[string]*poolStats)
+	for name, st := range c.closed {
+		st := st
+		byName[name] = &st
+	}
+	for p := range c.pools {
+		st := byName[p.name]
+		if st == nil {
+			st = &poolStats{}
+			byName[p.name] = st
+		}
+		s := p.Stat()
+		st.acquired += float64(s.AcquiredConns())
+		st.idle += float64(s.IdleConns())
+		st.constructing += float64(s.ConstructingConns())
+		st.max += float64(s.MaxConns())
+		st.acquires += float64(s.AcquireCount())
+		st.empty += float64(s.EmptyAcquireCount())
+		st.canceled += float64(s.CanceledAcquireCount())
+		st.acquireSeconds += s.AcquireDuration().Seconds()
+	}
+	c.mu.Unlock()
+
+	for name, st := range byName {
+		ch <- prometheus.MustNewConstMetric(descConns, prometheus.GaugeValue, st.acquired, name, "acquired")
+		ch <- prometheus.MustNewConstMetric(descConns, prometheus.GaugeValue, st.idle, name, "idle")
+		ch <- prometheus.MustNewConstMetric(descConns, prometheus.GaugeValue, st.constructing, name, "constructing")
+		ch <- prometheus.MustNewConstMetric(descMaxConns, prometheus.GaugeValue, st.max, name)
+		ch <- prometheus.MustNewConstMetric(descAcquires, prometheus.CounterValue, st.acquires, name)
+		ch <- prometheus.MustNewConstMetric(descEmptyAcquires, prometheus.CounterValue, st.empty, name)
+		ch <- prometheus.MustNewConstMetric(descCanceledAcquires, prometheus.CounterValue, st.canceled, name)
+		ch <- prometheus.MustNewConstMetric(descAcquireSeconds, prometheus.CounterValue, st.acquireSeconds, name)
+	}
+}
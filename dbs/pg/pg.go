@@ -0,0 +1,136 @@
+// Package pg creates Postgres connection pools with shared defaults and metrics.
+package pg
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+const (
+	// DefaultMaxConnLifetime is the default max time a connection is reused for.
+	DefaultMaxConnLifetime = time.Minute
+	// DefaultMaxConnIdleTime is the default max time a connection stays idle in the pool.
+	DefaultMaxConnIdleTime = 30 * time.Second
+	// CloudSQLSocketDir is a directory where Cloud Run mounts Cloud SQL sockets.
+	CloudSQLSocketDir = "/cloudsql"
+)
+
+// Option configures a connection pool.
+type Option func(c *pgxpool.Config)
+
+// WithMaxConns sets the max number of connections in the pool.
+func WithMaxConns(n int32) Option {
+	return func(c *pgxpool.Config) {
+		c.MaxConns = n
+	}
+}
+
+// WithConnLifetime sets the max lifetime and the max idle time of connections.
+// Zero values are ignored.
+func WithConnLifetime(lifetime, idle time.Duration) Option {
+	return func(c *pgxpool.Config) {
+		if lifetime > 0 {
+			c.MaxConnLifetime = lifetime
+		}
+		if idle > 0 {
+			c.MaxConnIdleTime = idle
+		}
+	}
+}
+
+// WithStatementTimeout aborts statements that take longer than d.
+func WithStatementTimeout(d time.Duration) Option {
+	return func(c *pgxpool.Config) {
+		c.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(d.Milliseconds(), 10)
+	}
+}
+
+// WithApplicationName sets application_name of connections. By default, it's taken from SERVICE_NAME.
+func WithApplicationName(name string) Option {
+	return func(c *pgxpool.Config) {
+		c.ConnConfig.RuntimeParams["application_name"] = name
+	}
+}
+
+// WithTLS connects with a given TLS config. Connections without TLS are not attempted.
+func WithTLS(conf *tls.Config) Option {
+	return func(c *pgxpool.Config) {
+		c.ConnConfig.TLSConfig = conf
+		c.ConnConfig.Fallbacks = nil
+	}
+}
+
+// WithCloudSQL connects over a Unix socket of the Cloud SQL instance, for example "project:region:instance".
+func WithCloudSQL(instance string) Option {
+	return func(c *pgxpool.Config) {
+		c.ConnConfig.Host = path.Join(CloudSQLSocketDir, instance)
+		c.ConnConfig.Port = 5432
+		c.ConnConfig.TLSConfig = nil
+		c.ConnConfig.Fallbacks = nil
+	}
+}
+
+// ProcessAddress removes parameters that are only supported by lib/pq.
+func ProcessAddress(uri string) string {
+	return strings.Replace(uri, "&binary_parameters=yes", "", -1)
+}
+
+// ParseConfig parses the URI and applies defaults and options.
+func ParseConfig(uri string, opts ...Option) (*pgxpool.Config, error) {
+	config, err := pgxpool.ParseConfig(ProcessAddress(uri))
+	if err != nil {
+		return nil, err
+	}
+	config.ConnConfig.PreferSimpleProtocol = true
+	config.MaxConnLifetime = DefaultMaxConnLifetime
+	config.MaxConnIdleTime = DefaultMaxConnIdleTime
+	if _, ok := config.ConnConfig.RuntimeParams["application_name"]; !ok {
+		if name := os.Getenv("SERVICE_NAME"); name != "" {
+			config.ConnConfig.RuntimeParams["application_name"] = name
+		}
+	}
+	for _, o := range opts {
+		o(config)
+	}
+	return config, nil
+}
+
+// Open creates a connection pool. The name is used in metrics to distinguish pools.
+func Open(ctx context.Context, name, uri string, opts ...Option) (*Pool, error) {
+	config, err := ParseConfig(uri, opts...)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	p := &Pool{Pool: pool, name: name}
+	stats.add(p)
+	return p, nil
+}
+
+// Pool is a connection pool that exports its statistics as Prometheus metrics.
+type Pool struct {
+	*pgxpool.Pool
+	name string
+}
+
+// Name of the pool used in metrics.
+func (p *Pool) Name() string {
+	return p.name
+}
+
+// Close closes all connections. Gauges of the pool are no longer exported,
+// while its counters are added to other pools with the same name.
+func (p *Pool) Close() {
+	p.Pool.Close()
+	stats.remove(p)
+}
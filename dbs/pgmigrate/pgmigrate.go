@@ -0,0 +1,217 @@
+// Package pgmigrate applies schema migrations embedded into packages that use Postgres.
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	"github.com/golang-migrate/migrate/v4/source"
+	"github.com/golang-migrate/migrate/v4/source/iofs"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	_ "github.com/lib/pq"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/report"
+)
+
+// codeUndefinedTable is the SQLSTATE code returned if the version table doesn't exist.
+const codeUndefinedTable = "42P01"
+
+// ErrSchemaBehind is returned by Check if the database schema is older than the one expected by the package.
+var ErrSchemaBehind = errors.New("db: schema version is behind")
+
+// Migrations is a set of versioned migrations in the golang-migrate format:
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+type Migrations struct {
+	// FS contains migration files, usually it's an embed.FS.
+	FS fs.FS
+	// Dir is a directory in FS with migration files.
+	Dir string
+	// Table stores the schema version. Each package uses its own table, thus they can share the database.
+	Table string
+
+	mu      sync.Mutex
+	checked bool // StartupCheck passed
+}
+
+func (m *Migrations) source() (source.Driver, error) {
+	return iofs.New(m.FS, m.Dir)
+}
+
+// Latest returns the latest version of migrations.
+func (m *Migrations) Latest() (uint, error) {
+	src, err := m.source()
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	vers, err := src.First()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		next, err := src.Next(vers)
+		if errors.Is(err, fs.ErrNotExist) {
+			return vers, nil
+		} else if err != nil {
+			return 0, err
+		}
+		vers = next
+	}
+}
+
+func (m *Migrations) open(ctx context.Context, uri string) (*migrate.Migrate, error) {
+	db, err := sql.Open("postgres", uri)
+	if err != nil {
+		return nil, err
+	}
+	if err = db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	drv, err := postgres.WithInstance(db, &postgres.Config{MigrationsTable: m.Table})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	src, err := m.source()
+	if err != nil {
+		drv.Close()
+		return nil, err
+	}
+	mg, err := migrate.NewWithInstance("iofs", src, "postgres", drv)
+	if err != nil {
+		src.Close()
+		drv.Close()
+		return nil, err
+	}
+	return mg, nil
+}
+
+// Up applies all migrations that were not applied yet.
+// If ctx is cancelled, it stops after the current migration.
+func (m *Migrations) Up(ctx context.Context, uri string) error {
+	mg, err := m.open(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer mg.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			mg.GracefulStop <- true
+		case <-done:
+		}
+	}()
+	if err = mg.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return fmt.Errorf("cannot migrate %s: %w", m.Table, err)
+	}
+	return ctx.Err()
+}
+
+// Version returns the current schema version of the database. It's zero if no migrations were applied.
+func (m *Migrations) Version(ctx context.Context, uri string) (vers uint, dirty bool, err error) {
+	mg, err := m.open(ctx, uri)
+	if err != nil {
+		return 0, false, err
+	}
+	defer mg.Close()
+	vers, dirty, err = mg.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	return vers, dirty, err
+}
+
+// VersionWith is similar to Version, but reads the version table with a given connection.
+// Unlike Version, it only runs a read-only query and doesn't take the migration lock.
+func (m *Migrations) VersionWith(ctx context.Context, q dbs.Querier) (vers uint, dirty bool, err error) {
+	vers, dirty, _, err = m.versionWith(ctx, q)
+	return vers, dirty, err
+}
+
+// versionWith is similar to VersionWith, but also reports if the version table exists.
+func (m *Migrations) versionWith(ctx context.Context, q dbs.Querier) (vers uint, dirty, managed bool, err error) {
+	var v int64
+	err = q.QueryRow(ctx, `SELECT version, dirty FROM `+pgx.Identifier{m.Table}.Sanitize()+` LIMIT 1;`).Scan(&v, &dirty)
+	var perr *pgconn.PgError
+	if errors.As(err, &perr) && perr.Code == codeUndefinedTable {
+		return 0, false, false, nil
+	} else if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, true, nil
+	} else if err != nil {
+		return 0, false, false, err
+	}
+	return uint(v), dirty, true, nil
+}
+
+// Check returns ErrSchemaBehind if the database schema is older than the latest migration,
+// or if the last migration failed.
+func (m *Migrations) Check(ctx context.Context, uri string) error {
+	vers, dirty, err := m.Version(ctx, uri)
+	if err != nil {
+		return err
+	}
+	return m.check(vers, dirty)
+}
+
+// CheckWith is similar to Check, but reads the version with a given connection, see VersionWith.
+func (m *Migrations) CheckWith(ctx context.Context, q dbs.Querier) error {
+	vers, dirty, err := m.VersionWith(ctx, q)
+	if err != nil {
+		return err
+	}
+	return m.check(vers, dirty)
+}
+
+func (m *Migrations) check(vers uint, dirty bool) error {
+	latest, err := m.Latest()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w: %s: version %d is dirty", ErrSchemaBehind, m.Table, vers)
+	} else if vers < latest {
+		return fmt.Errorf("%w: %s: version %d, expected %d", ErrSchemaBehind, m.Table, vers, latest)
+	}
+	return nil
+}
+
+// StartupCheck is similar to CheckWith, but it's skipped if DATABASE_SKIP_SCHEMA_CHECK=true.
+// It's used when databases are opened from the environment, so the service fails on startup
+// instead of failing requests. Once the check passes, it's not repeated in this process.
+//
+// Databases without the version table are not managed by migrations (for example, they were created
+// before migrations were embedded), thus the check only logs a warning for them.
+func (m *Migrations) StartupCheck(ctx context.Context, q dbs.Querier) error {
+	if os.Getenv("DATABASE_SKIP_SCHEMA_CHECK") == "true" {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.checked {
+		return nil
+	}
+	vers, dirty, managed, err := m.versionWith(ctx, q)
+	if err != nil {
+		return err
+	}
+	if !managed {
+		report.Message(ctx, "%s: no schema version table, skipping the schema check", m.Table)
+	} else if err = m.check(vers, dirty); err != nil {
+		return err
+	}
+	m.checked = true
+	return nil
+}
@@ -0,0 +1,100 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/fstest"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+func testMigrations() *Migrations {
+	return &Migrations{
+		FS: fstest.MapFS{
+			"migrations/000001_init.up.sql":     {Data: []byte("CREATE TABLE a (id int);")},
+			"migrations/000001_init.down.sql":   {Data: []byte("DROP TABLE a;")},
+			"migrations/000002_b.up.sql":        {Data: []byte("CREATE TABLE b (id int);")},
+			"migrations/000010_column.up.sql":   {Data: []byte("ALTER TABLE b ADD COLUMN c int;")},
+			"migrations/000010_column.down.sql": {Data: []byte("ALTER TABLE b DROP COLUMN c;")},
+		},
+		Dir:   "migrations",
+		Table: "test_schema_migrations",
+	}
+}
+
+func TestLatest(t *testing.T) {
+	m := testMigrations()
+	vers, err := m.Latest()
+	require.NoError(t, err)
+	require.Equal(t, uint(10), vers)
+
+	m.Dir = "missing"
+	_, err = m.Latest()
+	require.Error(t, err)
+}
+
+// versionRow is a row of the version table.
+type versionRow struct {
+	vers  int64
+	dirty bool
+	err   error
+}
+
+func (r versionRow) Scan(dest ...interface{}) error {
+	if r.err != nil {
+		return r.err
+	}
+	*dest[0].(*int64) = r.vers
+	*dest[1].(*bool) = r.dirty
+	return nil
+}
+
+// versionQuerier serves the version table and counts queries.
+type versionQuerier struct {
+	row     versionRow
+	queries int
+}
+
+func (q *versionQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (q *versionQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	q.queries++
+	return q.row
+}
+
+func TestStartupCheck(t *testing.T) {
+	ctx := context.Background()
+	m := testMigrations()
+
+	// no version table, the database is not managed by migrations
+	q := &versionQuerier{row: versionRow{err: &pgconn.PgError{Code: codeUndefinedTable}}}
+	require.NoError(t, m.StartupCheck(ctx, q))
+	require.ErrorIs(t, m.CheckWith(ctx, q), ErrSchemaBehind)
+
+	m = testMigrations()
+	q.row = versionRow{err: pgx.ErrNoRows}
+	require.ErrorIs(t, m.StartupCheck(ctx, q), ErrSchemaBehind)
+
+	q.row = versionRow{vers: 10, dirty: true}
+	require.ErrorIs(t, m.StartupCheck(ctx, q), ErrSchemaBehind)
+
+	q.row = versionRow{vers: 10}
+	q.queries = 0
+	require.NoError(t, m.StartupCheck(ctx, q))
+	require.Equal(t, 1, q.queries)
+
+	// the check is done once per process
+	q.row = versionRow{vers: 2}
+	require.NoError(t, m.StartupCheck(ctx, q))
+	require.Equal(t, 1, q.queries)
+	require.ErrorIs(t, m.CheckWith(ctx, q), ErrSchemaBehind)
+
+	m = testMigrations()
+	t.Setenv("DATABASE_SKIP_SCHEMA_CHECK", "true")
+	require.NoError(t, m.StartupCheck(ctx, q))
+}
@@ -0,0 +1,210 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v4"
+)
+
+// ScanFunc scans a single row into a value.
+type ScanFunc[T any] func(sc Scanner) (T, error)
+
+// Querier is implemented by pgxpool.Pool, pgx.Conn and pgx.Tx.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// TypedIterator is an iterator over values of a given type, for example service.Iterator.
+type TypedIterator[T any] interface {
+	IteratorBase
+	Value() *T
+}
+
+var _ TypedIterator[struct{}] = (*Iterator[struct{}])(nil)
+
+// NewIterator creates an iterator that scans rows with a given function.
+func NewIterator[T any](rows pgx.Rows, scan ScanFunc[T]) *Iterator[T] {
+	return &Iterator[T]{rows: rows, scan: scan}
+}
+
+// Iterator iterates over query results. It must be closed after use.
+type Iterator[T any] struct {
+	rows    pgx.Rows
+	scan    ScanFunc[T]
+	current *T
+
+	err error
+}
+
+func (it *Iterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if !it.rows.Next() {
+		return false
+	}
+	v, err := it.scan(it.rows)
+	if err != nil {
+		it.err = err
+		return false
+	}
+	it.current = &v
+	return true
+}
+
+func (it *Iterator[T]) Value() *T {
+	if it.err != nil {
+		return nil
+	}
+	return it.current
+}
+
+func (it *Iterator[T]) Err() error {
+	if it.err != nil {
+		return it.err
+	}
+	return it.rows.Err()
+}
+
+func (it *Iterator[T]) Close() error {
+	it.rows.Close()
+	return it.err
+}
+
+// Query runs the query and returns an iterator over the results.
+func Query[T any](ctx context.Context, q Querier, scan ScanFunc[T], sql string, args ...interface{}) (*Iterator[T], error) {
+	rows, err := q.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return NewIterator(rows, scan), nil
+}
+
+// Collect reads all values from the iterator and closes it.
+func Collect[T any](it TypedIterator[T]) ([]T, error) {
+	defer it.Close()
+	var out []T
+	for it.Next() {
+		out = append(out, *it.Value())
+	}
+	return out, it.Err()
+}
+
+// QueryAll runs the query and returns all results.
+func QueryAll[T any](ctx context.Context, q Querier, scan ScanFunc[T], sql string, args ...interface{}) ([]T, error) {
+	it, err := Query(ctx, q, scan, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return Collect[T](it)
+}
+
+// QueryOne runs the query and scans the first row. It returns ErrNotFound if there are no rows.
+func QueryOne[T any](ctx context.Context, q Querier, scan ScanFunc[T], sql string, args ...interface{}) (T, error) {
+	v, err := scan(q.QueryRow(ctx, sql, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		err = ErrNotFound
+	}
+	return v, err
+}
+
+// DefaultPageSize is the default number of rows fetched by KeysetIterator at once.
+const DefaultPageSize = 1000
+
+// Keyset describes a query that is read in pages ordered by a unique key.
+// Unlike OFFSET, the key allows to skip rows efficiently and is stable while rows are inserted.
+type Keyset[T any, K any] struct {
+	// Query returns the query and arguments for a page that starts after a given key.
+	// The key is nil for the first page. The query must be ordered by the key and limited to limit rows.
+	Query func(after *K, limit int) (string, []interface{})
+	// Scan scans a single row.
+	Scan ScanFunc[T]
+	// Key returns the key of the value.
+	Key func(v *T) K
+	// PageSize is the number of rows in a page. DefaultPageSize is used if it's zero.
+	PageSize int
+}
+
+// QueryKeyset returns an iterator that reads the query page by page.
+// Each page is a separate query, thus no connections are held between pages.
+func QueryKeyset[T any, K any](ctx context.Context, q Querier, ks Keyset[T, K]) *KeysetIterator[T, K] {
+	if ks.PageSize <= 0 {
+		ks.PageSize = DefaultPageSize
+	}
+	return &KeysetIterator[T, K]{ctx: ctx, q: q, ks: ks}
+}
+
+var _ TypedIterator[struct{}] = (*KeysetIterator[struct{}, int])(nil)
+
+// KeysetIterator iterates over the query results page by page, see Keyset.
+type KeysetIterator[T any, K any] struct {
+	ctx context.Context
+	q   Querier
+	ks  Keyset[T, K]
+
+	page    []T
+	current *T
+	last    *K
+	done    bool
+
+	err error
+}
+
+func (it *KeysetIterator[T, K]) fetch() error {
+	sql, args := it.ks.Query(it.last, it.ks.PageSize)
+	page, err := QueryAll(it.ctx, it.q, it.ks.Scan, sql, args...)
+	if err != nil {
+		return err
+	}
+	if len(page) < it.ks.PageSize {
+		it.done = true
+	}
+	if len(page) != 0 {
+		key := it.ks.Key(&page[len(page)-1])
+		it.last = &key
+	}
+	it.page = page
+	return nil
+}
+
+func (it *KeysetIterator[T, K]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	if len(it.page) == 0 {
+		if it.done {
+			it.current = nil
+			return false
+		}
+		if err := it.fetch(); err != nil {
+			it.err = err
+			return false
+		}
+		if len(it.page) == 0 {
+			it.current = nil
+			return false
+		}
+	}
+	it.current = &it.page[0]
+	it.page = it.page[1:]
+	return true
+}
+
+func (it *KeysetIterator[T, K]) Value() *T {
+	if it.err != nil {
+		return nil
+	}
+	return it.current
+}
+
+func (it *KeysetIterator[T, K]) Err() error {
+	return it.err
+}
+
+func (it *KeysetIterator[T, K]) Close() error {
+	it.page = nil
+	it.done = true
+	return it.err
+}
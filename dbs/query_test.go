@@ -0,0 +1,151 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgproto3/v2"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// sliceRows is pgx.Rows over a list of integers.
+type sliceRows struct {
+	vals []int
+	cur  int
+	err  error
+}
+
+func (r *sliceRows) Close()                                         {}
+func (r *sliceRows) Err() error                                     { return r.err }
+func (r *sliceRows) CommandTag() pgconn.CommandTag                  { return nil }
+func (r *sliceRows) FieldDescriptions() []pgproto3.FieldDescription { return nil }
+func (r *sliceRows) Values() ([]interface{}, error)                 { return []interface{}{r.cur}, nil }
+func (r *sliceRows) RawValues() [][]byte                            { return nil }
+
+func (r *sliceRows) Next() bool {
+	if len(r.vals) == 0 {
+		return false
+	}
+	r.cur, r.vals = r.vals[0], r.vals[1:]
+	return true
+}
+
+func (r *sliceRows) Scan(dest ...interface{}) error {
+	*dest[0].(*int) = r.cur
+	return nil
+}
+
+type sliceRow struct {
+	vals []int
+}
+
+func (r sliceRow) Scan(dest ...interface{}) error {
+	if len(r.vals) == 0 {
+		return pgx.ErrNoRows
+	}
+	*dest[0].(*int) = r.vals[0]
+	return nil
+}
+
+// intQuerier returns integers from the table that are greater than the first argument, if it's set,
+// and limits them by the second argument.
+type intQuerier struct {
+	table   []int
+	queries int
+}
+
+func (q *intQuerier) filter(args []interface{}) []int {
+	var out []int
+	for _, v := range q.table {
+		if len(args) > 0 && v <= args[0].(int) {
+			continue
+		}
+		out = append(out, v)
+	}
+	if len(args) > 1 && len(out) > args[1].(int) {
+		out = out[:args[1].(int)]
+	}
+	return out
+}
+
+func (q *intQuerier) Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error) {
+	q.queries++
+	return &sliceRows{vals: q.filter(args)}, nil
+}
+
+func (q *intQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return sliceRow{vals: q.filter(args)}
+}
+
+func scanInt(sc Scanner) (int, error) {
+	var v int
+	err := sc.Scan(&v)
+	return v, err
+}
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+	q := &intQuerier{table: []int{1, 2, 3}}
+
+	list, err := QueryAll(ctx, q, scanInt, "")
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2, 3}, list)
+
+	v, err := QueryOne(ctx, q, scanInt, "", 1)
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+	_, err = QueryOne(ctx, q, scanInt, "", 3)
+	require.Equal(t, ErrNotFound, err)
+
+	// scan errors stop the iteration
+	errScan := errors.New("scan failed")
+	it, err := Query(ctx, q, func(sc Scanner) (int, error) {
+		v, _ := scanInt(sc)
+		if v == 2 {
+			return 0, errScan
+		}
+		return v, nil
+	}, "")
+	require.NoError(t, err)
+	list, err = Collect[int](it)
+	require.Equal(t, errScan, err)
+	require.Equal(t, []int{1}, list)
+
+	// rows errors are returned as well
+	errRows := errors.New("conn closed")
+	list, err = Collect[int](NewIterator[int](&sliceRows{vals: []int{1}, err: errRows}, scanInt))
+	require.Equal(t, errRows, err)
+	require.Equal(t, []int{1}, list)
+}
+
+func TestQueryKeyset(t *testing.T) {
+	ctx := context.Background()
+	for _, n := range []int{0, 1, 2, 3, 7, 9} {
+		n := n
+		t.Run(fmt.Sprint(n), func(t *testing.T) {
+			q := &intQuerier{}
+			for i := 1; i <= n; i++ {
+				q.table = append(q.table, i*10)
+			}
+			it := QueryKeyset(ctx, q, Keyset[int, int]{
+				Query: func(after *int, limit int) (string, []interface{}) {
+					if after == nil {
+						return "", []interface{}{0, limit}
+					}
+					return "", []interface{}{*after, limit}
+				},
+				Scan:     scanInt,
+				Key:      func(v *int) int { return *v },
+				PageSize: 3,
+			})
+			list, err := Collect[int](it)
+			require.NoError(t, err)
+			require.Equal(t, q.table, list)
+			require.Equal(t, n/3+1, q.queries)
+		})
+	}
+}
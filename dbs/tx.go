@@ -0,0 +1,124 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+)
+
+const (
+	// DefaultTxRetries is the default number of transaction retries.
+	DefaultTxRetries = 5
+
+	defaultTxMinBackoff = 10 * time.Millisecond
+	defaultTxMaxBackoff = time.Second
+)
+
+// SQLSTATE codes of errors that are resolved by retrying the transaction.
+const (
+	codeSerializationFailure = "40001"
+	codeDeadlockDetected     = "40P01"
+	codeUniqueViolation      = "23505"
+)
+
+// IsRetryableTx checks if the transaction failed because of a concurrent transaction:
+// a serialization failure, a deadlock or a unique violation.
+func IsRetryableTx(err error) bool {
+	var perr *pgconn.PgError
+	if !errors.As(err, &perr) {
+		return false
+	}
+	switch perr.Code {
+	case codeSerializationFailure, codeDeadlockDetected, codeUniqueViolation:
+		return true
+	}
+	return false
+}
+
+// Beginner is implemented by pgxpool.Pool, pgx.Conn and pgx.Tx.
+type Beginner interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// TxOptions controls InTx.
+type TxOptions struct {
+	pgx.TxOptions
+	// Retries is the number of retries for retryable errors, see IsRetryableTx.
+	// DefaultTxRetries is used if it's zero, and negative values disable retries.
+	Retries int
+	// MinBackoff is the delay before the first retry. It is doubled for each next retry.
+	MinBackoff time.Duration
+	// MaxBackoff limits the delay between retries.
+	MaxBackoff time.Duration
+}
+
+// InTx runs fn in a transaction and commits it if fn succeeds. The transaction is retried with a backoff
+// if it failed because of a concurrent transaction, thus fn must be safe to call multiple times.
+//
+// If db is a transaction itself, fn runs in a savepoint, which is rolled back if fn fails.
+// Nested calls are not retried, since retryable errors abort the whole transaction:
+// the outermost InTx retries it instead.
+func InTx(ctx context.Context, db Beginner, opts TxOptions, fn func(tx pgx.Tx) error) error {
+	if _, ok := db.(pgx.Tx); ok {
+		return runTx(ctx, db, opts.TxOptions, fn)
+	}
+	retries := opts.Retries
+	if retries == 0 {
+		retries = DefaultTxRetries
+	}
+	backoff := opts.MinBackoff
+	if backoff <= 0 {
+		backoff = defaultTxMinBackoff
+	}
+	maxBackoff := opts.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultTxMaxBackoff
+	}
+	for attempt := 0; ; attempt++ {
+		err := runTx(ctx, db, opts.TxOptions, fn)
+		if err == nil || attempt >= retries || !IsRetryableTx(err) {
+			return err
+		}
+		// jitter prevents concurrent transactions from colliding again
+		d := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error)
+}
+
+func runTx(ctx context.Context, db Beginner, opts pgx.TxOptions, fn func(tx pgx.Tx) error) error {
+	var (
+		tx  pgx.Tx
+		err error
+	)
+	if b, ok := db.(txBeginner); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else {
+		tx, err = db.Begin(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err = fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,152 @@
+package dbs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTx counts transaction calls. Begin creates a nested transaction, similar to a savepoint.
+type fakeTx struct {
+	pgx.Tx
+	parent    *fakeTx
+	begins    int
+	commits   int
+	rollbacks int
+	done      bool
+}
+
+func (tx *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	tx.begins++
+	return &fakeTx{parent: tx}, nil
+}
+
+func (tx *fakeTx) Commit(ctx context.Context) error {
+	if tx.done {
+		return pgx.ErrTxClosed
+	}
+	tx.done = true
+	if tx.parent != nil {
+		tx.parent.commits++
+	}
+	return nil
+}
+
+func (tx *fakeTx) Rollback(ctx context.Context) error {
+	if tx.done {
+		return pgx.ErrTxClosed
+	}
+	tx.done = true
+	if tx.parent != nil {
+		tx.parent.rollbacks++
+	}
+	return nil
+}
+
+// fakePool begins top-level transactions. It's not a pgx.Tx itself.
+type fakePool struct {
+	root fakeTx
+	opts []pgx.TxOptions
+}
+
+func (p *fakePool) Begin(ctx context.Context) (pgx.Tx, error) {
+	return p.BeginTx(ctx, pgx.TxOptions{})
+}
+
+func (p *fakePool) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	p.opts = append(p.opts, opts)
+	return p.root.Begin(ctx)
+}
+
+func TestIsRetryableTx(t *testing.T) {
+	for code, exp := range map[string]bool{
+		"40001": true,
+		"40P01": true,
+		"23505": true,
+		"23502": false,
+		"42P01": false,
+	} {
+		err := fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: code})
+		require.Equal(t, exp, IsRetryableTx(err), code)
+	}
+	require.False(t, IsRetryableTx(errors.New("other")))
+	require.False(t, IsRetryableTx(nil))
+}
+
+func TestInTx(t *testing.T) {
+	ctx := context.Background()
+	opts := TxOptions{MinBackoff: time.Millisecond, MaxBackoff: 2 * time.Millisecond}
+	opts.IsoLevel = pgx.Serializable
+
+	// retried until it succeeds
+	p := &fakePool{}
+	calls := 0
+	err := InTx(ctx, p, opts, func(tx pgx.Tx) error {
+		calls++
+		if calls < 3 {
+			return &pgconn.PgError{Code: "40001"}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+	require.Equal(t, 3, p.root.begins)
+	require.Equal(t, 1, p.root.commits)
+	require.Equal(t, 2, p.root.rollbacks)
+	require.Equal(t, pgx.Serializable, p.opts[0].IsoLevel)
+
+	// permanent errors are not retried
+	p = &fakePool{}
+	errFailed := errors.New("failed")
+	err = InTx(ctx, p, opts, func(tx pgx.Tx) error {
+		return errFailed
+	})
+	require.Equal(t, errFailed, err)
+	require.Equal(t, 1, p.root.begins)
+	require.Equal(t, 1, p.root.rollbacks)
+
+	// retries are limited
+	p = &fakePool{}
+	opts.Retries = 2
+	err = InTx(ctx, p, opts, func(tx pgx.Tx) error {
+		return &pgconn.PgError{Code: "40P01"}
+	})
+	require.True(t, IsRetryableTx(err))
+	require.Equal(t, 3, p.root.begins)
+
+	p = &fakePool{}
+	opts.Retries = -1
+	err = InTx(ctx, p, opts, func(tx pgx.Tx) error {
+		return &pgconn.PgError{Code: "23505"}
+	})
+	require.True(t, IsRetryableTx(err))
+	require.Equal(t, 1, p.root.begins)
+}
+
+func TestInTxNested(t *testing.T) {
+	ctx := context.Background()
+	p := &fakePool{}
+	inner := 0
+	err := InTx(ctx, p, TxOptions{}, func(tx pgx.Tx) error {
+		// the failed savepoint is rolled back and is not retried
+		err := InTx(ctx, tx, TxOptions{}, func(tx pgx.Tx) error {
+			inner++
+			return &pgconn.PgError{Code: "23505"}
+		})
+		require.True(t, IsRetryableTx(err))
+		return InTx(ctx, tx, TxOptions{}, func(tx pgx.Tx) error {
+			inner++
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, 2, inner)
+	require.Equal(t, 1, p.root.begins)
+	require.Equal(t, 1, p.root.commits)
+}
@@ -0,0 +1,89 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/athenianco/cloud-common/dbs/pg"
+)
+
+var _ TestStore = (*pgDatabase)(nil)
+
+// pgDatabase is a postgres database where processed keys are stored.
+type pgDatabase struct {
+	db *pg.Pool
+}
+
+// OpenDatabaseFromEnv opens default postgres database based on environment variable:
+// DEDUP_DATABASE_URI
+//
+// It fails if the schema is behind, see Migrate.
+func OpenDatabaseFromEnv() (Store, error) {
+	dbURI := os.Getenv("DEDUP_DATABASE_URI")
+	if dbURI == "" {
+		return nil, errors.New("DEDUP_DATABASE_URI is not set")
+	}
+	ctx := context.Background()
+	db, err := openDatabase(ctx, dbURI)
+	if err != nil {
+		return nil, err
+	}
+	if err = migrations.StartupCheck(ctx, db.db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func OpenDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (Store, error) {
+	return openDatabase(ctx, dbURI, opts...)
+}
+
+func OpenTestDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (TestStore, error) {
+	return openDatabase(ctx, dbURI, opts...)
+}
+
+// openDatabase opens postgres connection
+func openDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (*pgDatabase, error) {
+	conn, err := pg.Open(ctx, "dedup", dbURI, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &pgDatabase{db: conn}, nil
+}
+
+func (db *pgDatabase) Seen(ctx context.Context, key string) (bool, error) {
+	var seen bool
+	err := db.db.QueryRow(ctx, `SELECT true FROM dedup_keys WHERE key = $1 AND expires_at > NOW();`, key).Scan(&seen)
+	if err == pgx.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return seen, nil
+}
+
+func (db *pgDatabase) MarkDone(ctx context.Context, key string, ttl time.Duration) error {
+	_, err := db.db.Exec(ctx, `INSERT INTO dedup_keys(key, expires_at) VALUES($1, $2)
+ON CONFLICT(key) DO UPDATE SET expires_at = EXCLUDED.expires_at;`, key, time.Now().Add(ttl).UTC())
+	return err
+}
+
+func (db *pgDatabase) Expire(ctx context.Context) error {
+	_, err := db.db.Exec(ctx, `DELETE FROM dedup_keys WHERE expires_at <= NOW();`)
+	return err
+}
+
+func (db *pgDatabase) Cleanup(ctx context.Context) error {
+	_, err := db.db.Exec(ctx, `DELETE FROM dedup_keys;`)
+	return err
+}
+
+func (db *pgDatabase) Close() error {
+	db.db.Close()
+	return nil
+}
@@ -0,0 +1,27 @@
+package dedup_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/athenianco/cloud-common/dbs/pgtest"
+	"github.com/athenianco/cloud-common/dedup"
+)
+
+func TestPostgres(t *testing.T) {
+	pool, closer := pgtest.NewDatabasePoolWith(t, func(addr string) error {
+		return dedup.Migrate(context.Background(), addr)
+	})
+	defer closer()
+
+	addr, dbCloser := pool(t)
+	defer dbCloser()
+
+	db, err := dedup.OpenTestDatabase(context.Background(), addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	runStoreTest(t, db)
+}
@@ -0,0 +1,77 @@
+// Package dedup implements idempotent processing of Pub/Sub messages.
+package dedup
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is the default time for which processed keys are remembered.
+const DefaultTTL = 7 * 24 * time.Hour
+
+// Store remembers keys of processed messages.
+type Store interface {
+	// Seen checks if the key was already processed and is not expired yet.
+	Seen(ctx context.Context, key string) (bool, error)
+	// MarkDone marks the key as processed. The record expires after the given TTL.
+	MarkDone(ctx context.Context, key string, ttl time.Duration) error
+	// Expire removes all expired records.
+	Expire(ctx context.Context) error
+	Close() error
+}
+
+type TestStore interface {
+	Store
+	Cleanup(ctx context.Context) error
+}
+
+var _ TestStore = (*MemStore)(nil)
+
+// NewMemStore creates an in-memory Store that is useful for testing.
+func NewMemStore() *MemStore {
+	return &MemStore{keys: make(map[string]time.Time)}
+}
+
+// MemStore is an in-memory Store implementation.
+type MemStore struct {
+	mu   sync.Mutex
+	keys map[string]time.Time
+}
+
+func (s *MemStore) Seen(ctx context.Context, key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	exp, ok := s.keys[key]
+	return ok && time.Now().Before(exp), nil
+}
+
+func (s *MemStore) MarkDone(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[key] = time.Now().Add(ttl)
+	return nil
+}
+
+func (s *MemStore) Expire(ctx context.Context) error {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, exp := range s.keys {
+		if !now.Before(exp) {
+			delete(s.keys, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Cleanup(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = make(map[string]time.Time)
+	return nil
+}
+
+func (s *MemStore) Close() error {
+	return nil
+}
@@ -0,0 +1,46 @@
+package dedup_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dedup"
+)
+
+func runStoreTest(t *testing.T, s dedup.TestStore) {
+	ctx := context.Background()
+	defer func() {
+		require.NoError(t, s.Cleanup(ctx))
+	}()
+
+	seen := func(key string, exp bool) {
+		ok, err := s.Seen(ctx, key)
+		require.NoError(t, err)
+		require.Equal(t, exp, ok, key)
+	}
+
+	seen("a", false)
+	require.NoError(t, s.MarkDone(ctx, "a", time.Hour))
+	seen("a", true)
+	seen("b", false)
+
+	// marking twice extends the TTL
+	require.NoError(t, s.MarkDone(ctx, "b", -time.Second))
+	seen("b", false)
+	require.NoError(t, s.MarkDone(ctx, "b", time.Hour))
+	seen("b", true)
+
+	require.NoError(t, s.MarkDone(ctx, "c", -time.Second))
+	seen("c", false)
+	require.NoError(t, s.Expire(ctx))
+	seen("a", true)
+	seen("b", true)
+	seen("c", false)
+}
+
+func TestMemStore(t *testing.T) {
+	runStoreTest(t, dedup.NewMemStore())
+}
@@ -0,0 +1,121 @@
+package dedup
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/athenianco/cloud-common/funcs"
+	gtypes "github.com/athenianco/cloud-common/github/types"
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+// KeyFunc extracts a deduplication key from the message.
+// Empty key means the message cannot be deduplicated by this function.
+type KeyFunc func(ctx context.Context, msg *pubsub.Message) string
+
+// ByMessageID uses Pub/Sub message ID as a deduplication key.
+func ByMessageID(ctx context.Context, msg *pubsub.Message) string {
+	md, _ := pubsub.GetMetadata(ctx)
+	if md.ID == "" {
+		return ""
+	}
+	return "msg:" + md.ID
+}
+
+// ByEventID uses Github event ID from the JSON payload (see github/types.RepoEvent) as a deduplication key.
+func ByEventID(ctx context.Context, msg *pubsub.Message) string {
+	var ev struct {
+		EventID gtypes.EventID `json:"event_id"`
+	}
+	if err := json.Unmarshal(msg.Data, &ev); err != nil || ev.EventID == "" {
+		return ""
+	}
+	return "event:" + string(ev.EventID)
+}
+
+// Wrap the handler to skip messages that were already processed successfully.
+//
+// Namespace is prepended to all keys, thus different handlers can share the same store.
+// The message is skipped if any of the keys was seen. If no key functions are set, ByMessageID is used.
+//
+// The returned handler implements funcs.RetryPolicyHandler if h implements it, and forwards Shutdown to h.
+//
+// Note that concurrent deliveries of the same message are not deduplicated.
+func Wrap(h funcs.PubSubHandler, s Store, namespace string, ttl time.Duration, keys ...KeyFunc) funcs.PubSubHandler {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if len(keys) == 0 {
+		keys = []KeyFunc{ByMessageID}
+	}
+	dh := &handler{h: h, s: s, ns: namespace, ttl: ttl, keys: keys}
+	if ph, ok := h.(funcs.RetryPolicyHandler); ok {
+		return &policyHandler{handler: dh, ph: ph}
+	}
+	return dh
+}
+
+var (
+	_ funcs.Shutdowner         = (*handler)(nil)
+	_ funcs.RetryPolicyHandler = (*policyHandler)(nil)
+)
+
+// policyHandler is a handler that keeps the retry policy of the wrapped handler.
+type policyHandler struct {
+	*handler
+	ph funcs.RetryPolicyHandler
+}
+
+func (h *policyHandler) RetryPolicy() *funcs.RetryPolicy {
+	return h.ph.RetryPolicy()
+}
+
+type handler struct {
+	h    funcs.PubSubHandler
+	s    Store
+	ns   string
+	ttl  time.Duration
+	keys []KeyFunc
+}
+
+func (h *handler) Init() error {
+	return h.h.Init()
+}
+
+// Shutdown calls Shutdown of the wrapped handler, if it's implemented.
+func (h *handler) Shutdown(ctx context.Context) error {
+	if s, ok := h.h.(funcs.Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	return nil
+}
+
+func (h *handler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	keys := make([]string, 0, len(h.keys))
+	for _, fnc := range h.keys {
+		if key := fnc(ctx, msg); key != "" {
+			keys = append(keys, h.ns+":"+key)
+		}
+	}
+	for _, key := range keys {
+		seen, err := h.s.Seen(ctx, key)
+		if err != nil {
+			return err
+		} else if seen {
+			report.Info(ctx, "skipping duplicate message: %s", key)
+			return nil
+		}
+	}
+	if err := h.h.HandleMessage(ctx, msg); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		// the message was already processed, so redelivering it would only cause duplicates
+		if err := h.s.MarkDone(ctx, key, h.ttl); err != nil {
+			report.Error(ctx, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package dedup_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dedup"
+	"github.com/athenianco/cloud-common/funcs"
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+type countHandler struct {
+	calls int
+	err   error
+}
+
+func (h *countHandler) Init() error { return nil }
+
+func (h *countHandler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	h.calls++
+	return h.err
+}
+
+func TestWrap(t *testing.T) {
+	ctx := context.Background()
+	store := dedup.NewMemStore()
+	ch := &countHandler{}
+	h := dedup.Wrap(ch, store, "test", 0, dedup.ByMessageID, dedup.ByEventID)
+	require.NoError(t, h.Init())
+
+	deliver := func(id string, data string) {
+		ctx := pubsub.WithMetadata(ctx, pubsub.Metadata{ID: id})
+		require.NoError(t, h.HandleMessage(ctx, &pubsub.Message{Data: []byte(data)}))
+	}
+
+	deliver("1", `{"event_id":"ev1"}`)
+	require.Equal(t, 1, ch.calls)
+	// redelivery
+	deliver("1", `{"event_id":"ev1"}`)
+	require.Equal(t, 1, ch.calls)
+	// same event published twice
+	deliver("2", `{"event_id":"ev1"}`)
+	require.Equal(t, 1, ch.calls)
+	deliver("3", `{"event_id":"ev2"}`)
+	require.Equal(t, 2, ch.calls)
+	// no keys at all
+	deliver("", `{}`)
+	deliver("", `{}`)
+	require.Equal(t, 4, ch.calls)
+
+	// failed messages are not marked as done
+	ch.err = errors.New("fail")
+	ctx4 := pubsub.WithMetadata(ctx, pubsub.Metadata{ID: "4"})
+	require.Error(t, h.HandleMessage(ctx4, &pubsub.Message{Data: []byte(`{}`)}))
+	ch.err = nil
+	deliver("4", `{}`)
+	require.Equal(t, 6, ch.calls)
+	deliver("4", `{}`)
+	require.Equal(t, 6, ch.calls)
+
+	// namespaces are independent
+	h2 := dedup.Wrap(ch, store, "other", 0)
+	require.NoError(t, h2.HandleMessage(pubsub.WithMetadata(ctx, pubsub.Metadata{ID: "1"}), &pubsub.Message{}))
+	require.Equal(t, 7, ch.calls)
+}
+
+type policyHandler struct {
+	countHandler
+	policy   *funcs.RetryPolicy
+	shutdown bool
+}
+
+func (h *policyHandler) RetryPolicy() *funcs.RetryPolicy { return h.policy }
+
+func (h *policyHandler) Shutdown(ctx context.Context) error {
+	h.shutdown = true
+	return nil
+}
+
+func TestWrapInterfaces(t *testing.T) {
+	store := dedup.NewMemStore()
+
+	// optional interfaces of the wrapped handler are kept
+	ph := &policyHandler{policy: &funcs.RetryPolicy{MaxAttempts: 3}}
+	h := dedup.Wrap(ph, store, "test", 0)
+	rh, ok := h.(funcs.RetryPolicyHandler)
+	require.True(t, ok)
+	require.Same(t, ph.policy, rh.RetryPolicy())
+	require.NoError(t, h.(funcs.Shutdowner).Shutdown(context.Background()))
+	require.True(t, ph.shutdown)
+
+	// and not added if it doesn't implement them
+	h = dedup.Wrap(&countHandler{}, store, "test", 0)
+	_, ok = h.(funcs.RetryPolicyHandler)
+	require.False(t, ok)
+	require.NoError(t, h.(funcs.Shutdowner).Shutdown(context.Background()))
+}
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS dedup_keys (
+    key text NOT NULL,
+    expires_at timestamptz NOT NULL,
+    PRIMARY KEY(key)
+);
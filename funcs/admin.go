@@ -0,0 +1,175 @@
+package funcs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+// DefaultCheckTimeout is the default timeout for all readiness checks.
+const DefaultCheckTimeout = 5 * time.Second
+
+// CheckFunc checks the health of a dependency.
+type CheckFunc func(ctx context.Context) error
+
+// Pinger is implemented by database pools, for example pgxpool.Pool.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// PingCheck checks a database pool.
+func PingCheck(p Pinger) CheckFunc {
+	return p.Ping
+}
+
+// PublisherCheck checks that the Pub/Sub topic of the publisher exists.
+func PublisherCheck(p pubsub.MinPublisher) CheckFunc {
+	return func(ctx context.Context) error {
+		return pubsub.CheckPublisher(ctx, p)
+	}
+}
+
+// AdminOption configures admin endpoints.
+type AdminOption func(a *Admin)
+
+// WithCheck adds a readiness check.
+func WithCheck(name string, fn CheckFunc) AdminOption {
+	return func(a *Admin) {
+		a.AddCheck(name, fn)
+	}
+}
+
+// WithMetricsEndpoint enables /metrics for Prometheus scraping.
+func WithMetricsEndpoint() AdminOption {
+	return func(a *Admin) {
+		a.metrics = true
+	}
+}
+
+// WithCheckTimeout sets the timeout for all readiness checks.
+func WithCheckTimeout(d time.Duration) AdminOption {
+	return func(a *Admin) {
+		a.timeout = d
+	}
+}
+
+// NewAdmin creates admin endpoints: /healthz, /readyz and, optionally, /metrics.
+//
+// The service is not ready until SetReady is called. Lifecycle does it after the service is registered,
+// and resets it when the shutdown starts.
+func NewAdmin(opts ...AdminOption) *Admin {
+	a := &Admin{timeout: DefaultCheckTimeout}
+	for _, o := range opts {
+		o(a)
+	}
+	return a
+}
+
+// Admin serves health, readiness and metrics endpoints.
+type Admin struct {
+	ready   atomic.Bool
+	metrics bool
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks []namedCheck
+}
+
+type namedCheck struct {
+	name string
+	fn   CheckFunc
+}
+
+// AddCheck adds a readiness check.
+func (a *Admin) AddCheck(name string, fn CheckFunc) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.checks = append(a.checks, namedCheck{name: name, fn: fn})
+}
+
+// SetReady marks the service as ready or not ready to serve requests.
+func (a *Admin) SetReady(ready bool) {
+	a.ready.Store(ready)
+}
+
+// Register admin endpoints on the mux.
+func (a *Admin) Register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", a.serveHealth)
+	mux.HandleFunc("/readyz", a.serveReady)
+	if a.metrics {
+		mux.Handle("/metrics", promhttp.Handler())
+	}
+}
+
+// Handler returns a mux with admin endpoints only.
+func (a *Admin) Handler() http.Handler {
+	mux := http.NewServeMux()
+	a.Register(mux)
+	return mux
+}
+
+func (a *Admin) serveHealth(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("ok"))
+}
+
+// readyStatus is the body of /readyz response.
+type readyStatus struct {
+	Ready  bool              `json:"ready"`
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+// Check runs all readiness checks concurrently and returns errors by check name.
+func (a *Admin) Check(ctx context.Context) map[string]error {
+	a.mu.Lock()
+	checks := a.checks
+	a.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, a.timeout)
+	defer cancel()
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs = make(map[string]error)
+	)
+	for _, c := range checks {
+		c := c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := c.fn(ctx)
+			mu.Lock()
+			errs[c.name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return errs
+}
+
+func (a *Admin) serveReady(w http.ResponseWriter, r *http.Request) {
+	st := readyStatus{Ready: a.ready.Load()}
+	if st.Ready {
+		errs := a.Check(r.Context())
+		st.Checks = make(map[string]string, len(errs))
+		for name, err := range errs {
+			if err != nil {
+				st.Ready = false
+				st.Checks[name] = err.Error()
+			} else {
+				st.Checks[name] = "ok"
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !st.Ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(st)
+}
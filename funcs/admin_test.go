@@ -0,0 +1,72 @@
+package funcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+type pingerFunc func(ctx context.Context) error
+
+func (f pingerFunc) Ping(ctx context.Context) error { return f(ctx) }
+
+func TestAdmin(t *testing.T) {
+	var dbErr error
+	a := NewAdmin(
+		WithCheck("db", PingCheck(pingerFunc(func(ctx context.Context) error { return dbErr }))),
+		WithCheck("topic", PublisherCheck(pubsub.NewMemPublisher())),
+		WithMetricsEndpoint(),
+	)
+	srv := httptest.NewServer(a.Handler())
+	defer srv.Close()
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(srv.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+	ready := func() (int, readyStatus) {
+		code, body := get("/readyz")
+		var st readyStatus
+		require.NoError(t, json.Unmarshal([]byte(body), &st))
+		return code, st
+	}
+
+	code, _ := get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+
+	code, st := ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.False(t, st.Ready)
+
+	a.SetReady(true)
+	code, st = ready()
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, readyStatus{Ready: true, Checks: map[string]string{"db": "ok", "topic": "ok"}}, st)
+
+	dbErr = errors.New("connection refused")
+	code, st = ready()
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, readyStatus{Checks: map[string]string{"db": "connection refused", "topic": "ok"}}, st)
+
+	code, body := get("/metrics")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "go_goroutines")
+}
+
+func TestAdminDisabledMetrics(t *testing.T) {
+	w := httptest.NewRecorder()
+	NewAdmin().Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
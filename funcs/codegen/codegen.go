@@ -149,8 +149,9 @@ func (g *Generator) generateHTTPFunc(w io.Writer, f *Func) error {
 var _ funcs.WebhookHandler = &%s{}
 
 var %s struct{
-	once sync.Once
-	h %s
+	once  sync.Once
+	h     %s
+	watch *service.Watcher
 }
 
 // %sFunc is an auto-generate wrapper for a cloud function. See %s for details.
@@ -162,14 +163,16 @@ func %sFunc(w http.ResponseWriter, r *http.Request) {
 		if err := f.h.Init(); err != nil {
 			panic(err)
 		}
-		enabled, err := service.Register(r.Context())
+		_, watch, err := service.RegisterFromEnv(r.Context())
 		if err != nil {
 			panic(err)
 		}
-		if !enabled {
-			panic(report.NewIgnoredError(errors.New("disabled")))
-		}
+		f.watch = watch
 	})
+	if f.watch != nil && !f.watch.Refresh(r.Context()) {
+		http.Error(w, service.ErrDisabled.Error(), http.StatusServiceUnavailable)
+		return
+	}
 	ctx, cancel := common.EnsureTimeout(r.Context())
 	defer cancel()
 	r = r.WithContext(ctx)
@@ -191,8 +194,9 @@ func (g *Generator) generatePubSubFunc(w io.Writer, f *Func) error {
 var _ funcs.PubSubHandler = &%s{}
 
 var %s struct{
-	once sync.Once
-	h %s
+	once  sync.Once
+	h     %s
+	watch *service.Watcher
 }
 
 // %sFunc is an auto-generate wrapper for a cloud function. See %s for details.
@@ -204,14 +208,15 @@ func %sFunc(ctx context.Context, msg *pubsub.Message) error {
 		if err := f.h.Init(); err != nil {
 			panic(err)
 		}
-		enabled, err := service.Register(ctx)
+		_, watch, err := service.RegisterFromEnv(ctx)
 		if err != nil {
 			panic(err)
 		}
-		if !enabled {
-			panic(report.NewIgnoredError(errors.New("disabled")))
-		}
+		f.watch = watch
 	})
+	if f.watch != nil && !f.watch.Refresh(ctx) {
+		return service.ErrDisabled
+	}
 	ctx, cancel := common.EnsureTimeout(ctx)
 	defer cancel()
 	return f.h.HandleMessage(ctx, msg)
@@ -254,7 +259,6 @@ package %s
 
 import (
 	"context"
-	"errors"
 	"net/http"
 	"sync"
 	"time"
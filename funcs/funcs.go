@@ -3,18 +3,21 @@ package funcs
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
+	"os"
 	"time"
 
 	common "github.com/athenianco/cloud-common"
+	"github.com/athenianco/cloud-common/dbs"
 	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/pubsub/blob/gcs"
 	"github.com/athenianco/cloud-common/report"
 	"github.com/athenianco/cloud-common/report/sentry"
 	"github.com/athenianco/cloud-common/service"
 )
 
-const port = ":8080"
-
 // Initializer is an interface for stateful services that require initialization.
 // Zero value of the implementation must be usable for calling Init.
 type Initializer interface {
@@ -36,16 +39,32 @@ type PubSubHandler interface {
 	HandleMessage(ctx context.Context, msg *pubsub.Message) error
 }
 
+// RunHTTP initializes the handler and serves requests with a default Lifecycle.
+// It returns false if the service is disabled.
 func RunHTTP(h WebhookHandler) bool {
+	return RunHTTPWith(NewLifecycle(), h)
+}
+
+// RunHTTPWith initializes the handler and serves requests until the shutdown. It returns false if the service is disabled.
+//
+// If the service is regulated, its state is watched at runtime, and requests are rejected while it's disabled.
+// If the handler implements Shutdowner, it's called before other shutdown hooks.
+func RunHTTPWith(l *Lifecycle, h WebhookHandler) bool {
 	ctx := context.Background()
 
-	defer report.Flush(time.Minute)
+	served := false
+	defer func() {
+		// Lifecycle flushes reports on its own
+		if !served {
+			report.Flush(l.FlushTimeout)
+		}
+	}()
 	defer sentry.RecoverAndPanic(ctx)
 
 	if err := h.Init(); err != nil {
 		panic(err)
 	}
-	enabled, err := service.Register(ctx)
+	enabled, watch, err := l.registerService(ctx)
 	if err != nil {
 		panic(err)
 	}
@@ -53,9 +72,27 @@ func RunHTTP(h WebhookHandler) bool {
 		report.Info(ctx, "service disabled")
 		return false
 	}
+	var handler http.Handler = h
+	if watch != nil {
+		l.OnClose("service watcher", watch)
+		go watch.Run(ctx)
+		handler = watch.HTTPMiddleware(h)
+		if l.Admin != nil {
+			l.Admin.AddCheck("service", func(ctx context.Context) error {
+				if !watch.Enabled() {
+					return service.ErrDisabled
+				}
+				return nil
+			})
+		}
+	}
+	if s, ok := h.(Shutdowner); ok {
+		l.OnShutdown("handler", s.Shutdown)
+	}
 
-	http.Handle("/", h)
-	if err := http.ListenAndServe(port, nil); err != nil {
+	http.Handle("/", handler)
+	served = true
+	if err := l.Serve(ctx, http.DefaultServeMux); err != nil {
 		panic(err)
 	}
 	return true
@@ -63,6 +100,66 @@ func RunHTTP(h WebhookHandler) bool {
 
 type pubsubHandler struct {
 	PubSubHandler
+	policy *RetryPolicy
+	auth   *PushAuth
+	blobs  pubsub.BlobStore
+}
+
+func (h *pubsubHandler) Init() error {
+	if err := h.PubSubHandler.Init(); err != nil {
+		return err
+	}
+	h.auth = PushAuthFromEnv()
+	if os.Getenv("PUBSUB_BLOB_BUCKET") != "" {
+		blobs, err := gcs.NewStoreFromEnv(context.Background())
+		if err != nil {
+			return err
+		}
+		h.blobs = blobs
+	}
+	if ph, ok := h.PubSubHandler.(RetryPolicyHandler); ok {
+		h.policy = ph.RetryPolicy()
+		return nil
+	}
+	policy, err := RetryPolicyFromEnv()
+	if err != nil {
+		return err
+	}
+	h.policy = policy
+	return nil
+}
+
+// handle decodes the payload and processes the message according to the retry policy, if it's set.
+// Decoding errors follow the policy as well, while without the policy invalid payloads are reported and dropped.
+func (h *pubsubHandler) handle(ctx context.Context, msg *pubsub.Message, attempt int) error {
+	ph := &payloadHandler{PubSubHandler: h.PubSubHandler, blobs: h.blobs}
+	if h.policy != nil {
+		return h.policy.Handle(ctx, ph, msg, attempt)
+	}
+	err := ph.HandleMessage(ctx, msg)
+	if errors.Is(err, pubsub.ErrInvalidPayload) {
+		report.Error(ctx, err)
+		return report.NewIgnoredError(err)
+	}
+	return err
+}
+
+// payloadHandler resolves claim checks and decompresses messages before handling them.
+type payloadHandler struct {
+	PubSubHandler
+	blobs pubsub.BlobStore
+}
+
+func (h *payloadHandler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	m, err := pubsub.DecodePayload(ctx, msg, h.blobs)
+	if errors.Is(err, dbs.ErrNotFound) {
+		// the blob is gone, retrying won't help
+		report.Error(ctx, err)
+		return report.NewIgnoredError(err)
+	} else if err != nil {
+		return err
+	}
+	return h.PubSubHandler.HandleMessage(ctx, m)
 }
 
 func (h *pubsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -70,26 +167,71 @@ func (h *pubsubHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer report.Flush(time.Minute)
 	defer sentry.RecoverAndPanic(ctx)
 
-	// https://github.com/GoogleCloudPlatform/golang-samples/blob/31bb00e8dd7407c229442f37fb8b99d24df15233/eventarc/pubsub/main.go#L31
-	var msg struct {
-		Message pubsub.Message `json:"message"`
+	if h.auth != nil {
+		if err := h.auth.Verify(r); err != nil {
+			handleErr(ctx, w, report.NewIgnoredError(err), http.StatusUnauthorized)
+			return
+		}
 	}
 
-	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+	// https://github.com/GoogleCloudPlatform/golang-samples/blob/31bb00e8dd7407c229442f37fb8b99d24df15233/eventarc/pubsub/main.go#L31
+	var env pushEnvelope
+	if err := json.NewDecoder(r.Body).Decode(&env); err != nil {
 		handleErr(ctx, w, err, http.StatusBadRequest)
 		return
 	}
+	ctx = pubsub.WithMetadata(ctx, env.Metadata())
 	ctx, cancel := common.EnsureTimeout(ctx)
 	defer cancel()
-	if err := h.HandleMessage(ctx, &msg.Message); err != nil {
-		// TODO: better status codes
-		handleErr(ctx, w, err, http.StatusInternalServerError)
+	msg := &env.Message.Message
+	ctx = pubsub.RestoreContext(ctx, msg.Attrs)
+	if err := h.handle(ctx, msg, env.DeliveryAttempt); err != nil {
+		if isIgnored(err) {
+			// acknowledge the message
+			report.Debug(ctx, "dropping message: %v", err)
+			return
+		}
+		status := http.StatusInternalServerError
+		if IsRetryable(err) {
+			status = http.StatusServiceUnavailable
+		}
+		handleErr(ctx, w, err, status)
 		return
 	}
 }
 
+// MessageHandler adapts PubSubHandler to be used with pubsub.Subscriber.
+func MessageHandler(h PubSubHandler) pubsub.Handler {
+	return func(ctx context.Context, msg pubsub.Message) error {
+		return h.HandleMessage(ctx, &msg)
+	}
+}
+
+// Shutdown calls Shutdown of the handler, if it's implemented, and releases the blob store
+// and the retry policy.
+func (h *pubsubHandler) Shutdown(ctx context.Context) error {
+	var errs []error
+	if s, ok := h.PubSubHandler.(Shutdowner); ok {
+		errs = append(errs, s.Shutdown(ctx))
+	}
+	if h.policy != nil {
+		errs = append(errs, h.policy.Close())
+	}
+	if c, ok := h.blobs.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// RunPubSub initializes the handler and serves Pub/Sub push requests with a default Lifecycle.
+// It returns false if the service is disabled.
 func RunPubSub(h PubSubHandler) bool {
-	return RunHTTP(&pubsubHandler{h})
+	return RunPubSubWith(NewLifecycle(), h)
+}
+
+// RunPubSubWith is similar to RunHTTPWith, but serves Pub/Sub push requests.
+func RunPubSubWith(l *Lifecycle, h PubSubHandler) bool {
+	return RunHTTPWith(l, &pubsubHandler{PubSubHandler: h})
 }
 
 func handleErr(ctx context.Context, w http.ResponseWriter, err error, status int) {
@@ -0,0 +1,95 @@
+package funcs_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/funcs"
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+	"github.com/athenianco/cloud-common/service"
+)
+
+// doubler multiplies the number in the message and passes it to the next stage.
+type doubler struct {
+	out pubsub.Publisher
+}
+
+func (h *doubler) Init() error { return nil }
+
+func (h *doubler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	v, err := strconv.Atoi(string(msg.Data))
+	if err != nil {
+		return err
+	}
+	return h.out.Publish(ctx, []byte(strconv.Itoa(v*2)))
+}
+
+func TestPubSubPipeline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	b := pubsub.NewMemBroker()
+	out := pubsub.NewMemPublisher()
+
+	stages := []struct {
+		topic string
+		h     funcs.PubSubHandler
+	}{
+		{"in", &doubler{out: b.Publisher("mid")}},
+		{"mid", &doubler{out: out}},
+	}
+	errc := make(chan error, len(stages))
+	var subs []pubsub.Subscriber
+	for _, st := range stages {
+		require.NoError(t, st.h.Init())
+		sub, err := b.Subscribe(st.topic, st.topic+"-sub", pubsub.SubscriptionConfig{})
+		require.NoError(t, err)
+		subs = append(subs, sub)
+		h := funcs.MessageHandler(st.h)
+		go func() {
+			errc <- sub.Receive(ctx, h)
+		}()
+	}
+
+	require.NoError(t, b.Publisher("in").Publish(ctx, []byte("1"), []byte("2"), []byte("3")))
+	require.NoError(t, b.Wait(ctx))
+	for _, sub := range subs {
+		require.NoError(t, sub.Close())
+	}
+	for range stages {
+		require.NoError(t, <-errc)
+	}
+
+	var got []int
+	for _, m := range out.GetEvents() {
+		v, err := strconv.Atoi(string(m.Data))
+		require.NoError(t, err)
+		got = append(got, v)
+	}
+	require.ElementsMatch(t, []int{4, 8, 12}, got)
+}
+
+func TestRunDisabledService(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("SERVICE_NAME", "test-disabled")
+	db := service.NewMemDatabase()
+	_, err := db.RegisterService(ctx, "test-disabled")
+	require.NoError(t, err)
+	require.NoError(t, db.SwitchService(ctx, "test-disabled", false))
+
+	l := funcs.NewLifecycle()
+	l.Services = db
+	require.False(t, funcs.RunPubSubWith(l, &doubler{}))
+
+	// the instance is registered anyway
+	it, err := db.ListInstances(ctx, "test-disabled")
+	require.NoError(t, err)
+	defer it.Close()
+	require.True(t, it.Next())
+	require.Equal(t, report.InstanceID(), it.Value().ID)
+}
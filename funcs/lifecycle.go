@@ -0,0 +1,213 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/athenianco/cloud-common/report"
+	"github.com/athenianco/cloud-common/service"
+)
+
+const (
+	// DefaultPort is used if PORT is not set.
+	DefaultPort = "8080"
+	// DefaultDrainTimeout is the default time given to in-flight requests to finish on shutdown.
+	// Cloud Run gives 10 seconds after SIGTERM before killing the container.
+	DefaultDrainTimeout = 6 * time.Second
+	// DefaultShutdownTimeout is the default time given to shutdown hooks.
+	DefaultShutdownTimeout = 2 * time.Second
+	// DefaultFlushTimeout is the default time given to report.Flush on shutdown.
+	// Together with DefaultDrainTimeout and DefaultShutdownTimeout, it fits into the time given by Cloud Run.
+	DefaultFlushTimeout = 2 * time.Second
+)
+
+// Shutdowner is implemented by handlers that need to release resources on shutdown.
+type Shutdowner interface {
+	// Shutdown is called after the server stopped accepting requests and in-flight requests are drained.
+	Shutdown(ctx context.Context) error
+}
+
+// NewLifecycle creates a lifecycle manager with default settings.
+func NewLifecycle() *Lifecycle {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = DefaultPort
+	}
+	return &Lifecycle{
+		Addr:         ":" + port,
+		DrainTimeout:    DefaultDrainTimeout,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		FlushTimeout:    DefaultFlushTimeout,
+		Signals:         []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+	}
+}
+
+// Lifecycle serves HTTP requests until a signal is received, and then shuts down gracefully:
+// it drains in-flight requests, runs shutdown hooks and flushes reports.
+type Lifecycle struct {
+	// Addr to listen on. Defaults to PORT environment variable, or DefaultPort.
+	Addr string
+	// DrainTimeout is the time given to in-flight requests to finish.
+	DrainTimeout time.Duration
+	// ShutdownTimeout is the time given to all shutdown hooks. Hooks that are still running after it
+	// are abandoned, so that reports can be flushed.
+	ShutdownTimeout time.Duration
+	// FlushTimeout is the time given to report.Flush.
+	FlushTimeout time.Duration
+	// Signals that trigger the shutdown.
+	Signals []os.Signal
+	// Admin endpoints are served along with the handler, if set.
+	// The service is marked as ready when it starts serving requests, and as not ready when the shutdown starts.
+	Admin *Admin
+	// Services is the database of service states used by RunHTTPWith, if set.
+	// The service name is still taken from SERVICE_NAME. By default, the database is opened
+	// from the environment, see service.Register.
+	Services service.Database
+
+	mu    sync.Mutex
+	hooks []shutdownHook
+}
+
+type shutdownHook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// OnShutdown registers a hook that is called on shutdown. Hooks are called in the reverse order,
+// similar to defer, thus resources should be registered in the order they are created.
+func (l *Lifecycle) OnShutdown(name string, fn func(ctx context.Context) error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, shutdownHook{name: name, fn: fn})
+}
+
+// OnClose registers a closer that is called on shutdown, for example service.Database or pkey.Provider.
+// See OnShutdown for details.
+func (l *Lifecycle) OnClose(name string, c io.Closer) {
+	l.OnShutdown(name, func(ctx context.Context) error {
+		return c.Close()
+	})
+}
+
+// Serve listens on Addr and serves requests until ctx is cancelled or a signal is received.
+// See ServeListener for details.
+func (l *Lifecycle) Serve(ctx context.Context, h http.Handler) error {
+	ln, err := net.Listen("tcp", l.Addr)
+	if err != nil {
+		l.shutdown(ctx)
+		return err
+	}
+	return l.ServeListener(ctx, ln, h)
+}
+
+// ServeListener serves requests on the listener until ctx is cancelled or a signal is received.
+//
+// After that, it stops accepting requests, waits up to DrainTimeout for in-flight requests,
+// calls shutdown hooks and flushes reports.
+func (l *Lifecycle) ServeListener(ctx context.Context, ln net.Listener, h http.Handler) error {
+	ctx, stop := signal.NotifyContext(ctx, l.Signals...)
+	defer stop()
+
+	if l.Admin != nil {
+		mux := http.NewServeMux()
+		l.Admin.Register(mux)
+		mux.Handle("/", h)
+		h = mux
+		l.Admin.SetReady(true)
+	}
+	srv := &http.Server{Handler: h}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- srv.Serve(ln)
+	}()
+
+	var err error
+	select {
+	case err = <-errc:
+	case <-ctx.Done():
+		report.Info(context.Background(), "shutting down")
+		if l.Admin != nil {
+			l.Admin.SetReady(false)
+		}
+		dctx, cancel := context.WithTimeout(context.Background(), l.DrainTimeout)
+		if serr := srv.Shutdown(dctx); serr != nil {
+			report.Error(dctx, fmt.Errorf("cannot drain requests: %w", serr))
+			_ = srv.Close()
+		}
+		cancel()
+		err = <-errc
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	l.shutdown(context.Background())
+	return err
+}
+
+// registerService registers the service and creates a watcher for it.
+// The watcher is nil if the service is disabled or is not regulated.
+func (l *Lifecycle) registerService(ctx context.Context) (bool, *service.Watcher, error) {
+	if l.Services == nil {
+		enabled, watch, err := service.RegisterFromEnv(ctx)
+		if err != nil {
+			return false, nil, err
+		} else if !enabled {
+			if watch != nil {
+				watch.Close()
+			}
+			return false, nil, nil
+		}
+		return true, watch, nil
+	}
+	name := os.Getenv("SERVICE_NAME")
+	if name == "" {
+		// service is not regulated
+		return true, nil, nil
+	}
+	enabled, err := service.RegisterWith(ctx, l.Services, name)
+	if err != nil || !enabled {
+		return false, nil, err
+	}
+	watch, err := service.NewWatcherWith(l.Services, name)
+	return true, watch, err
+}
+
+// shutdown calls all hooks in the reverse order, waiting up to ShutdownTimeout for them, and flushes reports.
+func (l *Lifecycle) shutdown(ctx context.Context) {
+	l.mu.Lock()
+	hooks := l.hooks
+	l.hooks = nil
+	l.mu.Unlock()
+	if l.ShutdownTimeout > 0 {
+		var cancel func()
+		ctx, cancel = context.WithTimeout(ctx, l.ShutdownTimeout)
+		defer cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hk := hooks[i]
+			if err := hk.fn(ctx); err != nil {
+				report.Error(ctx, fmt.Errorf("shutdown %s: %w", hk.name, err))
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		report.Error(ctx, fmt.Errorf("shutdown hooks did not finish: %w", ctx.Err()))
+	}
+	if err := report.Flush(l.FlushTimeout); err != nil {
+		report.Error(ctx, fmt.Errorf("cannot flush reports: %w", err))
+	}
+}
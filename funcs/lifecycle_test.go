@@ -0,0 +1,151 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycleDefaults(t *testing.T) {
+	t.Setenv("PORT", "")
+	require.Equal(t, ":8080", NewLifecycle().Addr)
+	t.Setenv("PORT", "9090")
+	require.Equal(t, ":9090", NewLifecycle().Addr)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+func TestLifecycleShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := "http://" + ln.Addr().String()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		_, _ = io.WriteString(w, "ok")
+	})
+
+	var calls []string
+	l := NewLifecycle()
+	l.FlushTimeout = time.Second
+	l.OnShutdown("first", func(ctx context.Context) error {
+		calls = append(calls, "first")
+		return nil
+	})
+	l.OnClose("second", closerFunc(func() error {
+		calls = append(calls, "second")
+		return errors.New("ignored")
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- l.ServeListener(ctx, ln, h)
+	}()
+
+	resp, err := http.Get(addr + "/")
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	slow := make(chan error, 1)
+	go func() {
+		resp, err := http.Get(addr + "/slow")
+		if err == nil {
+			var body []byte
+			body, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			assert.Equal(t, "ok", string(body))
+		}
+		slow <- err
+	}()
+	<-started
+	cancel()
+
+	// the server waits for in-flight requests
+	select {
+	case <-done:
+		t.Fatal("server stopped before draining requests")
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.Empty(t, calls)
+	close(release)
+	require.NoError(t, <-slow)
+	require.NoError(t, <-done)
+	require.Equal(t, []string{"second", "first"}, calls)
+
+	_, err = http.Get(addr + "/")
+	require.Error(t, err)
+}
+
+func TestLifecycleSignal(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	l := NewLifecycle()
+	l.FlushTimeout = time.Second
+	l.Signals = []os.Signal{syscall.SIGUSR1}
+	stopped := make(chan struct{})
+	l.OnShutdown("hook", func(ctx context.Context) error {
+		close(stopped)
+		return nil
+	})
+	done := make(chan error, 1)
+	go func() {
+		done <- l.ServeListener(context.Background(), ln, http.NotFoundHandler())
+	}()
+	// make sure the server is up and the signal handler is installed
+	resp, err := http.Get("http://" + ln.Addr().String())
+	require.NoError(t, err)
+	resp.Body.Close()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	<-stopped
+}
+
+func TestLifecycleShutdownTimeout(t *testing.T) {
+	l := NewLifecycle()
+	l.ShutdownTimeout = 50 * time.Millisecond
+	l.FlushTimeout = time.Second
+
+	// hooks get the deadline
+	var deadline bool
+	l.OnShutdown("ctx", func(ctx context.Context) error {
+		_, deadline = ctx.Deadline()
+		return nil
+	})
+	l.shutdown(context.Background())
+	require.True(t, deadline)
+
+	// hooks that ignore the deadline are abandoned
+	block := make(chan struct{})
+	defer close(block)
+	l.OnShutdown("stuck", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+	start := time.Now()
+	l.shutdown(context.Background())
+	require.Less(t, time.Since(start), time.Second)
+}
@@ -0,0 +1,90 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"google.golang.org/api/idtoken"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+// pushEnvelope is the body of a Pub/Sub push request.
+//
+// See https://cloud.google.com/pubsub/docs/push#receive_push
+type pushEnvelope struct {
+	Message         pushMessage `json:"message"`
+	Subscription    string      `json:"subscription"`
+	DeliveryAttempt int         `json:"deliveryAttempt"`
+}
+
+type pushMessage struct {
+	pubsub.Message
+	ID          string    `json:"messageId"`
+	PublishTime time.Time `json:"publishTime"`
+}
+
+// Metadata returns delivery information from the envelope.
+func (e *pushEnvelope) Metadata() pubsub.Metadata {
+	return pubsub.Metadata{
+		Subscription:    e.Subscription,
+		ID:              e.Message.ID,
+		PublishTime:     e.Message.PublishTime,
+		DeliveryAttempt: e.DeliveryAttempt,
+		OrderingKey:     e.Message.OrderingKey,
+	}
+}
+
+// PushAuth verifies OIDC tokens that Pub/Sub attaches to push requests.
+type PushAuth struct {
+	// Audience is the expected audience of the token. It is set in the push subscription config.
+	Audience string
+	// Email is the expected service account email. Any account is accepted if it's empty.
+	Email string
+
+	// validate overrides token validation in tests.
+	validate func(ctx context.Context, token, audience string) (*idtoken.Payload, error)
+}
+
+// PushAuthFromEnv configures push authentication based on environment variables:
+// PUBSUB_PUSH_AUDIENCE and PUBSUB_PUSH_EMAIL. It returns nil if the audience is not set.
+func PushAuthFromEnv() *PushAuth {
+	aud := os.Getenv("PUBSUB_PUSH_AUDIENCE")
+	if aud == "" {
+		return nil
+	}
+	return &PushAuth{Audience: aud, Email: os.Getenv("PUBSUB_PUSH_EMAIL")}
+}
+
+// Verify checks the bearer token of the push request.
+func (a *PushAuth) Verify(r *http.Request) error {
+	const prefix = "Bearer "
+	hdr := r.Header.Get("Authorization")
+	if !strings.HasPrefix(hdr, prefix) {
+		return errors.New("missing bearer token")
+	}
+	token := strings.TrimSpace(hdr[len(prefix):])
+	validate := a.validate
+	if validate == nil {
+		validate = idtoken.Validate
+	}
+	payload, err := validate(r.Context(), token, a.Audience)
+	if err != nil {
+		return fmt.Errorf("invalid token: %w", err)
+	}
+	if a.Email == "" {
+		return nil
+	}
+	if verified, _ := payload.Claims["email_verified"].(bool); !verified {
+		return errors.New("token email is not verified")
+	}
+	if email, _ := payload.Claims["email"].(string); email != a.Email {
+		return fmt.Errorf("unexpected token email: %q", email)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package funcs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/idtoken"
+
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+type mdHandler struct {
+	md  pubsub.Metadata
+	msg *pubsub.Message
+	ctx map[string]interface{}
+}
+
+func (h *mdHandler) Init() error { return nil }
+
+func (h *mdHandler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	h.md, _ = pubsub.GetMetadata(ctx)
+	h.msg = msg
+	h.ctx = report.GetContextMap(ctx)
+	return nil
+}
+
+const testEnvelope = `{
+	"message": {
+		"attributes": {"k": "v"},
+		"data": "dGVzdA==",
+		"messageId": "2070443601311540",
+		"publishTime": "2021-02-26T19:13:55.749Z",
+		"orderingKey": "acc1"
+	},
+	"subscription": "projects/myproject/subscriptions/mysubscription",
+	"deliveryAttempt": 2
+}`
+
+func TestPushEnvelope(t *testing.T) {
+	mh := &mdHandler{}
+	h := &pubsubHandler{PubSubHandler: mh}
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(testEnvelope))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Equal(t, &pubsub.Message{
+		Data:        []byte("test"),
+		Attrs:       map[string]string{"k": "v"},
+		OrderingKey: "acc1",
+	}, mh.msg)
+	require.Equal(t, pubsub.Metadata{
+		Subscription:    "projects/myproject/subscriptions/mysubscription",
+		ID:              "2070443601311540",
+		PublishTime:     time.Date(2021, 2, 26, 19, 13, 55, 749000000, time.UTC),
+		DeliveryAttempt: 2,
+		OrderingKey:     "acc1",
+	}, mh.md)
+	require.Equal(t, "2070443601311540", mh.ctx["pubsub.message_id"])
+	require.Equal(t, int64(2), mh.ctx["pubsub.delivery_attempt"])
+}
+
+func TestPushAuth(t *testing.T) {
+	auth := &PushAuth{
+		Audience: "https://example.com/push",
+		Email:    "push@example.iam.gserviceaccount.com",
+		validate: func(ctx context.Context, token, audience string) (*idtoken.Payload, error) {
+			if token != "good" && token != "other" {
+				return nil, errors.New("bad signature")
+			}
+			email := "push@example.iam.gserviceaccount.com"
+			if token == "other" {
+				email = "other@example.iam.gserviceaccount.com"
+			}
+			return &idtoken.Payload{Audience: audience, Claims: map[string]interface{}{
+				"email":          email,
+				"email_verified": true,
+			}}, nil
+		},
+	}
+	for _, c := range []struct {
+		name   string
+		hdr    string
+		status int
+	}{
+		{"valid", "Bearer good", http.StatusOK},
+		{"missing", "", http.StatusUnauthorized},
+		{"invalid", "Bearer bad", http.StatusUnauthorized},
+		{"email", "Bearer other", http.StatusUnauthorized},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			mh := &mdHandler{}
+			h := &pubsubHandler{PubSubHandler: mh, auth: auth}
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(testEnvelope))
+			if c.hdr != "" {
+				req.Header.Set("Authorization", c.hdr)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			require.Equal(t, c.status, w.Code)
+			require.Equal(t, c.status == http.StatusOK, mh.msg != nil)
+		})
+	}
+}
+
+func TestPushCompressed(t *testing.T) {
+	ctx := context.Background()
+	mp := pubsub.NewMemPublisher()
+	p := pubsub.NewPayloadPublisher(mp, pubsub.PayloadSettings{Encoding: pubsub.EncodingGzip, MinCompressSize: 1})
+	data := []byte(strings.Repeat("test", 100))
+	require.NoError(t, p.PublishMsg(ctx, &pubsub.Message{Data: data, Attrs: map[string]string{"k": "v"}}))
+	events := mp.GetEvents()
+	require.Len(t, events, 1)
+
+	body, err := json.Marshal(pushEnvelope{Message: pushMessage{Message: events[0], ID: "1"}})
+	require.NoError(t, err)
+
+	mh := &mdHandler{}
+	h := &pubsubHandler{PubSubHandler: mh}
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, &pubsub.Message{Data: data, Attrs: map[string]string{"k": "v"}}, mh.msg)
+}
+
+func TestPushInvalidPayload(t *testing.T) {
+	msg := pubsub.Message{Data: []byte("test"), Attrs: map[string]string{pubsub.AttrContentEncoding: pubsub.EncodingGzip}}
+	body, err := json.Marshal(pushEnvelope{Message: pushMessage{Message: msg, ID: "1"}, DeliveryAttempt: 1})
+	require.NoError(t, err)
+	push := func(h http.Handler) int {
+		req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(body))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// without a retry policy, the message is dropped
+	mh := &mdHandler{}
+	require.Equal(t, http.StatusOK, push(&pubsubHandler{PubSubHandler: mh}))
+	require.Nil(t, mh.msg)
+
+	// with a retry policy, the message goes to the dead letter topic
+	dead := pubsub.NewMemPublisher()
+	h := &pubsubHandler{PubSubHandler: mh, policy: &RetryPolicy{MaxAttempts: 3, DeadLetter: dead}}
+	require.Equal(t, http.StatusOK, push(h))
+	require.Nil(t, mh.msg)
+	events := dead.GetEvents()
+	require.Len(t, events, 1)
+	require.Equal(t, msg.Data, events[0].Data)
+	require.Equal(t, pubsub.EncodingGzip, events[0].Attrs[pubsub.AttrContentEncoding])
+}
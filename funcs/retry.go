@@ -0,0 +1,187 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+const (
+	// AttrDeadLetterError is set on dead-lettered messages to the text of the last error.
+	AttrDeadLetterError = "com.athenian.dead_letter.error"
+
+	defaultMinBackoff = 100 * time.Millisecond
+	defaultMaxBackoff = 5 * time.Second
+)
+
+// RetryPolicy decides what to do with Pub/Sub messages that failed processing.
+//
+// Retryable errors (see IsRetryable) are retried in-process first, and then the message is returned
+// to Pub/Sub for redelivery. Once the delivery attempt reaches MaxAttempts, the message is published
+// to DeadLetter and acknowledged. Permanent errors are sent to DeadLetter immediately.
+// If DeadLetter is not set, such messages are returned to Pub/Sub for redelivery as well,
+// and the dead letter policy of the subscription applies, if any.
+//
+// Delivery attempts are only reported by Pub/Sub if the subscription has a dead letter policy.
+// If it's not the case, the message is redelivered until it expires.
+type RetryPolicy struct {
+	// MaxAttempts is the number of delivery attempts before sending the message to DeadLetter.
+	// Zero means no limit.
+	MaxAttempts int
+	// Retries is the number of in-process retries for retryable errors.
+	Retries int
+	// MinBackoff is the delay before the first in-process retry. It is doubled for each next retry.
+	MinBackoff time.Duration
+	// MaxBackoff limits the delay between in-process retries.
+	MaxBackoff time.Duration
+	// DeadLetter is a topic for messages that cannot be processed.
+	// If not set, such messages are redelivered by Pub/Sub.
+	DeadLetter pubsub.MinPublisher
+
+	// closeDeadLetter is set if DeadLetter was created by the policy and must be closed by it.
+	closeDeadLetter bool
+}
+
+// RetryPolicyHandler is an optional interface for PubSubHandler that sets a custom retry policy.
+// It is called after Init.
+type RetryPolicyHandler interface {
+	RetryPolicy() *RetryPolicy
+}
+
+// RetryPolicyFromEnv creates a retry policy based on environment variables:
+// PUBSUB_MAX_DELIVERY_ATTEMPTS, PUBSUB_RETRIES and PUBSUB_DEAD_LETTER_TOPIC.
+// It returns nil if none of them is set. The policy must be closed after use.
+func RetryPolicyFromEnv() (*RetryPolicy, error) {
+	var (
+		p   RetryPolicy
+		set bool
+	)
+	for _, v := range []struct {
+		env string
+		ptr *int
+	}{
+		{"PUBSUB_MAX_DELIVERY_ATTEMPTS", &p.MaxAttempts},
+		{"PUBSUB_RETRIES", &p.Retries},
+	} {
+		s := os.Getenv(v.env)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", v.env, err)
+		}
+		*v.ptr = n
+		set = true
+	}
+	if topic := os.Getenv("PUBSUB_DEAD_LETTER_TOPIC"); topic != "" {
+		pub, err := pubsub.NewPublisher(topic)
+		if err != nil {
+			return nil, err
+		}
+		p.DeadLetter = pub
+		p.closeDeadLetter = true
+		set = true
+	}
+	if !set {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// IsRetryable checks if the error is temporary, thus processing of the message should be retried.
+func IsRetryable(err error) bool {
+	var terr interface {
+		Temporary() bool
+	}
+	return errors.As(err, &terr) && terr.Temporary()
+}
+
+// isIgnored checks if the message should be acknowledged despite the error.
+func isIgnored(err error) bool {
+	var ierr report.IgnoredError
+	return errors.As(err, &ierr) && ierr.Ignored()
+}
+
+func (p *RetryPolicy) backoff(i int) time.Duration {
+	minB, maxB := p.MinBackoff, p.MaxBackoff
+	if minB <= 0 {
+		minB = defaultMinBackoff
+	}
+	if maxB <= 0 {
+		maxB = defaultMaxBackoff
+	}
+	d := minB << uint(i)
+	if d <= 0 || d > maxB {
+		d = maxB
+	}
+	return d
+}
+
+// retry calls the handler and retries it in-process on retryable errors.
+func (p *RetryPolicy) retry(ctx context.Context, h PubSubHandler, msg *pubsub.Message) error {
+	err := h.HandleMessage(ctx, msg)
+	for i := 0; i < p.Retries && err != nil && IsRetryable(err); i++ {
+		report.Debug(ctx, "retrying message: %v", err)
+		t := time.NewTimer(p.backoff(i))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+		err = h.HandleMessage(ctx, msg)
+	}
+	return err
+}
+
+// Handle processes the message according to the policy. Attempt is the delivery attempt reported
+// by Pub/Sub, or zero if unknown.
+//
+// It returns nil if the message must be acknowledged, or an error if it must be redelivered.
+func (p *RetryPolicy) Handle(ctx context.Context, h PubSubHandler, msg *pubsub.Message, attempt int) error {
+	err := p.retry(ctx, h, msg)
+	if err == nil || isIgnored(err) {
+		return err
+	}
+	if IsRetryable(err) && (p.MaxAttempts <= 0 || attempt < p.MaxAttempts) {
+		return err
+	}
+	if p.DeadLetter == nil {
+		return err
+	}
+	report.Error(ctx, err)
+	if derr := p.deadLetter(ctx, msg, attempt, err); derr != nil {
+		return fmt.Errorf("cannot publish to dead letter topic: %w", derr)
+	}
+	report.Info(ctx, "message sent to dead letter topic after %d attempts", attempt)
+	return nil
+}
+
+// Close releases the dead letter publisher, if it was created by RetryPolicyFromEnv.
+func (p *RetryPolicy) Close() error {
+	if !p.closeDeadLetter {
+		return nil
+	}
+	return pubsub.ClosePublisher(p.DeadLetter)
+}
+
+func (p *RetryPolicy) deadLetter(ctx context.Context, msg *pubsub.Message, attempt int, err error) error {
+	attrs := make(map[string]string, len(msg.Attrs)+2)
+	for k, v := range msg.Attrs {
+		attrs[k] = v
+	}
+	attrs[pubsub.AttrDeadLetterDeliveryCount] = strconv.Itoa(attempt)
+	attrs[AttrDeadLetterError] = err.Error()
+	return p.DeadLetter.PublishMsg(ctx, &pubsub.Message{
+		Data:        msg.Data,
+		Attrs:       attrs,
+		OrderingKey: msg.OrderingKey,
+	})
+}
@@ -0,0 +1,119 @@
+package funcs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+type tempError struct {
+	error
+}
+
+func (tempError) Temporary() bool { return true }
+
+type errHandler struct {
+	errs  []error
+	calls int
+}
+
+func (h *errHandler) Init() error { return nil }
+
+func (h *errHandler) HandleMessage(ctx context.Context, msg *pubsub.Message) error {
+	h.calls++
+	if len(h.errs) == 0 {
+		return nil
+	}
+	err := h.errs[0]
+	h.errs = h.errs[1:]
+	return err
+}
+
+func pushRequest(t testing.TB, h http.Handler, attempt int) int {
+	body := fmt.Sprintf(`{"message":{"data":"dGVzdA==","attributes":{"k":"v"}},"deliveryAttempt":%d}`, attempt)
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestPushStatus(t *testing.T) {
+	temp := tempError{errors.New("temporary")}
+	for _, c := range []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"ok", nil, http.StatusOK},
+		{"ignored", report.NewIgnoredError(errors.New("ignored")), http.StatusOK},
+		{"temporary", temp, http.StatusServiceUnavailable},
+		{"permanent", errors.New("permanent"), http.StatusInternalServerError},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			h := &pubsubHandler{PubSubHandler: &errHandler{errs: []error{c.err}}}
+			require.Equal(t, c.status, pushRequest(t, h, 0))
+		})
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	temp := tempError{errors.New("temporary")}
+	dead := pubsub.NewMemPublisher()
+	policy := &RetryPolicy{
+		MaxAttempts: 3,
+		Retries:     2,
+		MinBackoff:  time.Millisecond,
+		DeadLetter:  dead,
+	}
+
+	// succeeds after in-process retries
+	eh := &errHandler{errs: []error{temp, temp}}
+	h := &pubsubHandler{PubSubHandler: eh, policy: policy}
+	require.Equal(t, http.StatusOK, pushRequest(t, h, 1))
+	require.Equal(t, 3, eh.calls)
+	require.Empty(t, dead.GetEvents())
+
+	// retries exhausted, but there are delivery attempts left
+	eh = &errHandler{errs: []error{temp, temp, temp}}
+	h = &pubsubHandler{PubSubHandler: eh, policy: policy}
+	require.Equal(t, http.StatusServiceUnavailable, pushRequest(t, h, 2))
+	require.Empty(t, dead.GetEvents())
+
+	// last attempt
+	eh = &errHandler{errs: []error{temp, temp, temp}}
+	h = &pubsubHandler{PubSubHandler: eh, policy: policy}
+	require.Equal(t, http.StatusOK, pushRequest(t, h, 3))
+	require.Equal(t, []pubsub.Message{{
+		Data: []byte("test"),
+		Attrs: map[string]string{
+			"k":                                "v",
+			pubsub.AttrDeadLetterDeliveryCount: "3",
+			AttrDeadLetterError:                "temporary",
+		},
+	}}, dead.GetEvents())
+
+	// permanent errors are not retried
+	eh = &errHandler{errs: []error{errors.New("permanent")}}
+	h = &pubsubHandler{PubSubHandler: eh, policy: policy}
+	require.Equal(t, http.StatusOK, pushRequest(t, h, 1))
+	require.Equal(t, 1, eh.calls)
+	require.Len(t, dead.GetEvents(), 1)
+
+	// without a dead letter topic, the message is redelivered
+	policy.DeadLetter = nil
+	eh = &errHandler{errs: []error{errors.New("permanent")}}
+	h = &pubsubHandler{PubSubHandler: eh, policy: policy}
+	require.Equal(t, http.StatusInternalServerError, pushRequest(t, h, 3))
+	require.Equal(t, 1, eh.calls)
+	require.NoError(t, h.Shutdown(context.Background()))
+}
@@ -53,6 +53,13 @@ func (list RepoEventFlags) Has(f RepoEventFlag) bool {
 	return false
 }
 
+const (
+	// RepoEventSchema is a Pub/Sub schema name for RepoEvent messages.
+	RepoEventSchema = "com.athenian.github.repo_event"
+	// RepoEventVersion is the current Pub/Sub schema version of RepoEvent messages.
+	RepoEventVersion = 1
+)
+
 type RepoEvent struct {
 	EventID      EventID        `json:"event_id"`
 	Timestamp    time.Time      `json:"ts,omitempty"`
@@ -76,6 +83,12 @@ func (ev *RepoEvent) EventContext() EventContext {
 	return ectx
 }
 
+// OrderingKey returns a Pub/Sub ordering key for the event.
+// Events for the same account share the key, thus they are delivered in order.
+func (ev *RepoEvent) OrderingKey() string {
+	return ev.AccID.String()
+}
+
 func WithRepoEvent(ctx context.Context, ev *RepoEvent) context.Context {
 	ctx = WithAccount(ctx, ev.AccID)
 	ctx = WithEvent(ctx, ev.EventID)
@@ -0,0 +1,21 @@
+package types
+
+import (
+	"context"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+// InstallationMiddleware sets installation attributes on published messages, based on the context.
+// See WithInstallation and AttrsWithInstallation.
+func InstallationMiddleware() pubsub.PublisherMiddleware {
+	return pubsub.AttrsMiddleware(func(ctx context.Context) map[string]string {
+		if ictx, ok := InstallationContext(ctx); ok {
+			return AttrsWithInstallation(nil, ictx)
+		}
+		if id, ok := AccountID(ctx); ok {
+			return AttrsWithAccount(nil, id)
+		}
+		return nil
+	})
+}
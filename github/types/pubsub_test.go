@@ -0,0 +1,28 @@
+package types
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+func TestInstallationMiddleware(t *testing.T) {
+	ctx := context.Background()
+	mp := pubsub.NewMemPublisher()
+	p := pubsub.WithMiddleware(mp, InstallationMiddleware())
+
+	require.NoError(t, p.Publish(ctx, []byte("1")))
+	ictx := InstallContext{
+		AppContext: AppContext{AthenianAppID: 1, AppID: 2},
+		AccountID:  3,
+		InstallID:  4,
+	}
+	require.NoError(t, p.Publish(WithInstallation(ctx, ictx), []byte("2")))
+	require.Equal(t, []pubsub.Message{
+		{Data: []byte("1")},
+		{Data: []byte("2"), Attrs: AttrsWithInstallation(nil, ictx)},
+	}, mp.GetEvents())
+}
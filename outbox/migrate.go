@@ -0,0 +1,27 @@
+package outbox
+
+import (
+	"context"
+	"embed"
+
+	"github.com/athenianco/cloud-common/dbs/pgmigrate"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+var migrations = &pgmigrate.Migrations{
+	FS:    migrationsFS,
+	Dir:   "migrations",
+	Table: "outbox_schema_migrations",
+}
+
+// Migrate applies schema migrations of the outbox table.
+func Migrate(ctx context.Context, uri string) error {
+	return migrations.Up(ctx, uri)
+}
+
+// CheckSchema returns pgmigrate.ErrSchemaBehind if migrations of the outbox table were not applied.
+func CheckSchema(ctx context.Context, uri string) error {
+	return migrations.Check(ctx, uri)
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial NOT NULL,
+    topic text NOT NULL,
+    data bytea NOT NULL,
+    attributes jsonb NOT NULL DEFAULT '{}',
+    ordering_key text NOT NULL DEFAULT '',
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT NOW(),
+    last_error text,
+    PRIMARY KEY(id)
+);
@@ -0,0 +1,2 @@
+-- the transaction that wrote the message, it defines the order in which messages are relayed
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS txid bigint NOT NULL DEFAULT txid_current();
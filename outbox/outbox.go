@@ -0,0 +1,49 @@
+// Package outbox implements the transactional outbox pattern for Pub/Sub.
+//
+// Messages are written to the outbox table in the same transaction as the rest of the data,
+// and then published by the Relay. This guarantees that messages of committed transactions are published
+// at least once, and messages of rolled back transactions are never published. See Relay for the delivery
+// and ordering guarantees.
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jackc/pgx/v4"
+
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+var _ pubsub.MinPublisher = (*txPublisher)(nil)
+
+// NewPublisher creates a publisher that writes messages for a given topic to the outbox table
+// in the provided transaction. Messages are sent only after the transaction is committed.
+func NewPublisher(tx pgx.Tx, topic string) pubsub.MinPublisher {
+	return &txPublisher{tx: tx, topic: topic}
+}
+
+type txPublisher struct {
+	tx    pgx.Tx
+	topic string
+}
+
+// PublishMsg stores messages in the outbox table.
+func (p *txPublisher) PublishMsg(ctx context.Context, msgs ...*pubsub.Message) error {
+	for _, m := range msgs {
+		attrs := m.Attrs
+		if attrs == nil {
+			attrs = map[string]string{}
+		}
+		attrsData, err := json.Marshal(attrs)
+		if err != nil {
+			return err
+		}
+		_, err = p.tx.Exec(ctx, `INSERT INTO outbox(topic, data, attributes, ordering_key) VALUES($1, $2, $3, $4);`,
+			p.topic, m.Data, string(attrsData), m.OrderingKey)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dbs/pgtest"
+	"github.com/athenianco/cloud-common/outbox"
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+func openPool(t testing.TB) (*pgxpool.Pool, func()) {
+	pool, closer := pgtest.NewDatabasePoolWith(t, func(addr string) error {
+		return outbox.Migrate(context.Background(), addr)
+	})
+	addr, dbCloser := pool(t)
+
+	config, err := pgxpool.ParseConfig(addr)
+	require.NoError(t, err)
+	config.ConnConfig.PreferSimpleProtocol = true
+	db, err := pgxpool.ConnectConfig(context.Background(), config)
+	require.NoError(t, err)
+	return db, func() {
+		db.Close()
+		dbCloser()
+		closer()
+	}
+}
+
+// flakyPublisher fails the first publish for a given message.
+type flakyPublisher struct {
+	mu   sync.Mutex
+	fail map[string]bool
+	p    *pubsub.MemPublisher
+}
+
+func (p *flakyPublisher) PublishMsg(ctx context.Context, msgs ...*pubsub.Message) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, m := range msgs {
+		if p.fail[string(m.Data)] {
+			delete(p.fail, string(m.Data))
+			return errors.New("publish failed")
+		}
+	}
+	return p.p.PublishMsg(ctx, msgs...)
+}
+
+func TestOutbox(t *testing.T) {
+	ctx := context.Background()
+	db, closer := openPool(t)
+	defer closer()
+
+	write := func(commit bool, msgs ...*pubsub.Message) {
+		err := db.BeginTxFunc(ctx, pgx.TxOptions{}, func(tx pgx.Tx) error {
+			if err := outbox.NewPublisher(tx, "topic").PublishMsg(ctx, msgs...); err != nil {
+				return err
+			}
+			if !commit {
+				return errors.New("rollback")
+			}
+			return nil
+		})
+		if commit {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+		}
+	}
+
+	out := &flakyPublisher{p: pubsub.NewMemPublisher(), fail: map[string]bool{"a2": true}}
+	relay := outbox.NewRelay(db, map[string]pubsub.MinPublisher{"topic": out})
+	relay.MinBackoff = 100 * time.Millisecond
+
+	write(false, &pubsub.Message{Data: []byte("lost")})
+	write(true,
+		&pubsub.Message{Data: []byte("a1"), OrderingKey: "a"},
+		&pubsub.Message{Data: []byte("a2"), OrderingKey: "a", Attrs: map[string]string{"k": "v"}},
+		&pubsub.Message{Data: []byte("b1"), OrderingKey: "b"},
+		&pubsub.Message{Data: []byte("a3"), OrderingKey: "a"},
+		&pubsub.Message{Data: []byte("x")},
+	)
+
+	data := func(msgs []pubsub.Message) []string {
+		var out []string
+		for _, m := range msgs {
+			out = append(out, string(m.Data))
+		}
+		return out
+	}
+
+	n, err := relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	// a3 must wait for a2
+	require.Equal(t, []string{"a1", "b1", "x"}, data(out.p.GetEvents()))
+
+	n, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	time.Sleep(2 * relay.MinBackoff)
+	n, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	events := out.p.GetEvents()
+	require.Equal(t, []string{"a2", "a3"}, data(events))
+	require.Equal(t, map[string]string{"k": "v"}, events[0].Attrs)
+	require.Equal(t, "a", events[0].OrderingKey)
+
+	n, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	// messages are held until transactions that started earlier are finished
+	tx1, err := db.Begin(ctx)
+	require.NoError(t, err)
+	defer tx1.Rollback(ctx)
+	err = outbox.NewPublisher(tx1, "topic").PublishMsg(ctx, &pubsub.Message{Data: []byte("c1"), OrderingKey: "c"})
+	require.NoError(t, err)
+	write(true, &pubsub.Message{Data: []byte("c2"), OrderingKey: "c"})
+	n, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.NoError(t, tx1.Commit(ctx))
+	n, err = relay.Drain(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.Equal(t, []string{"c1", "c2"}, data(out.p.GetEvents()))
+}
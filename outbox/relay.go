@@ -0,0 +1,288 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+const (
+	// relayLockID is an advisory lock that allows only one relay to claim messages at a time.
+	relayLockID = 0x6f7574626f78 // "outbox"
+
+	defaultBatchSize  = 100
+	defaultInterval   = time.Second
+	defaultLease      = time.Minute
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 5 * time.Minute
+)
+
+// Relay publishes messages from the outbox table to Pub/Sub.
+//
+// Messages are delivered at least once: if the relay fails after publishing a message, but before
+// removing it from the outbox, the message is published again.
+//
+// Messages that fail to publish are retried with an exponential backoff. Messages with the same
+// topic and ordering key are published in the order their transactions started, and in the order
+// they were written within a transaction. If one of them fails, the following ones are held until it is published.
+// To keep this order, messages are only relayed once all transactions that started before theirs are finished,
+// thus long-running transactions in the database delay the relay.
+//
+// Messages are claimed for Lease in a short transaction and published outside of it, thus multiple relays
+// can run concurrently. Claimed messages hold the following messages with the same ordering key,
+// the same way as failed ones.
+type Relay struct {
+	db   *pgxpool.Pool
+	pubs map[string]pubsub.MinPublisher
+
+	// BatchSize is the max number of messages published in one Drain call.
+	BatchSize int
+	// Interval is the delay between Drain calls in Run.
+	Interval time.Duration
+	// Lease is the time given to publish claimed messages. If the relay fails to report the result
+	// in time, the messages are claimed and published again.
+	Lease time.Duration
+	// MinBackoff is the delay before the first retry. It is doubled for each next retry.
+	MinBackoff time.Duration
+	// MaxBackoff limits the delay between retries.
+	MaxBackoff time.Duration
+}
+
+// NewRelay creates a relay for the outbox table in a given database.
+// Publishers map topic names used in NewPublisher to real publishers.
+func NewRelay(db *pgxpool.Pool, pubs map[string]pubsub.MinPublisher) *Relay {
+	return &Relay{
+		db:         db,
+		pubs:       pubs,
+		BatchSize:  defaultBatchSize,
+		Interval:   defaultInterval,
+		Lease:      defaultLease,
+		MinBackoff: defaultMinBackoff,
+		MaxBackoff: defaultMaxBackoff,
+	}
+}
+
+type outboxMsg struct {
+	id       int64
+	topic    string
+	msg      pubsub.Message
+	attempts int
+}
+
+func scanMsg(sc dbs.Scanner) (outboxMsg, error) {
+	var (
+		m     outboxMsg
+		attrs string
+	)
+	err := sc.Scan(&m.id, &m.topic, &m.msg.Data, &attrs, &m.msg.OrderingKey, &m.attempts)
+	if err != nil {
+		return m, err
+	}
+	if err = json.Unmarshal([]byte(attrs), &m.msg.Attrs); err != nil {
+		return m, err
+	}
+	if len(m.msg.Attrs) == 0 {
+		m.msg.Attrs = nil
+	}
+	return m, nil
+}
+
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.MinBackoff << uint(attempts)
+	if d <= 0 || d > r.MaxBackoff {
+		d = r.MaxBackoff
+	}
+	return d
+}
+
+// Run drains the outbox periodically until the context is cancelled.
+func (r *Relay) Run(ctx context.Context) error {
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		n, _, err := r.drain(ctx)
+		if err != nil {
+			report.Error(ctx, err)
+		}
+		if n >= r.BatchSize {
+			// there might be more messages
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// Drain publishes one batch of pending messages. It returns the number of messages that were published
+// and removed from the outbox. Messages that failed to publish are not counted.
+func (r *Relay) Drain(ctx context.Context) (int, error) {
+	_, published, err := r.drain(ctx)
+	return published, err
+}
+
+// drain is similar to Drain, but it also returns the number of claimed messages, including failed ones.
+func (r *Relay) drain(ctx context.Context) (claimed, published int, err error) {
+	msgs, err := r.claim(ctx)
+	if err != nil || len(msgs) == 0 {
+		return 0, 0, err
+	}
+	pctx, cancel := context.WithTimeout(ctx, r.Lease)
+	res := r.publish(pctx, msgs)
+	cancel()
+
+	var done, skipped []int64
+	for i, m := range msgs {
+		err := res[i].Err
+		switch {
+		case err == nil:
+			done = append(done, m.id)
+		case errors.Is(err, pubsub.ErrOrderingKeyPaused):
+			// not attempted, it's held by the failed message instead
+			skipped = append(skipped, m.id)
+		default:
+			report.Error(ctx, fmt.Errorf("outbox: cannot publish message %d to %q: %w", m.id, m.topic, err))
+		}
+	}
+	err = dbs.InTx(ctx, r.db, dbs.TxOptions{}, func(tx pgx.Tx) error {
+		for i, m := range msgs {
+			err := res[i].Err
+			if err == nil || errors.Is(err, pubsub.ErrOrderingKeyPaused) {
+				continue
+			}
+			_, err = tx.Exec(ctx, `UPDATE outbox SET attempts = attempts + 1, next_attempt_at = $2, last_error = $3 WHERE id = $1;`,
+				m.id, time.Now().Add(r.backoff(m.attempts)).UTC(), err.Error())
+			if err != nil {
+				return err
+			}
+		}
+		if len(skipped) != 0 {
+			if _, err := tx.Exec(ctx, `UPDATE outbox SET next_attempt_at = NOW() WHERE id = ANY($1);`, skipped); err != nil {
+				return err
+			}
+		}
+		if len(done) != 0 {
+			if _, err := tx.Exec(ctx, `DELETE FROM outbox WHERE id = ANY($1);`, done); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return len(msgs), len(done), nil
+}
+
+// claim selects messages ready to be published and delays them for the lease time,
+// so they are not claimed again while they are published.
+func (r *Relay) claim(ctx context.Context) ([]outboxMsg, error) {
+	var msgs []outboxMsg
+	err := dbs.InTx(ctx, r.db, dbs.TxOptions{}, func(tx pgx.Tx) error {
+		msgs = nil
+		var locked bool
+		if err := tx.QueryRow(ctx, `SELECT pg_try_advisory_xact_lock($1);`, int64(relayLockID)).Scan(&locked); err != nil {
+			return err
+		} else if !locked {
+			// other relay is claiming messages
+			return nil
+		}
+		var err error
+		msgs, err = r.pending(ctx, tx)
+		if err != nil || len(msgs) == 0 {
+			return err
+		}
+		ids := make([]int64, 0, len(msgs))
+		for _, m := range msgs {
+			ids = append(ids, m.id)
+		}
+		_, err = tx.Exec(ctx, `UPDATE outbox SET next_attempt_at = $2 WHERE id = ANY($1);`,
+			ids, time.Now().Add(r.Lease).UTC())
+		return err
+	})
+	return msgs, err
+}
+
+// pending returns messages ready to be published, in the order they were written.
+// Messages that follow a delayed message with the same ordering key are excluded, as well as messages
+// of transactions that started after the oldest running transaction, since it may still write
+// messages that must go first.
+func (r *Relay) pending(ctx context.Context, tx pgx.Tx) ([]outboxMsg, error) {
+	return dbs.QueryAll(ctx, tx, scanMsg, `SELECT o.id, o.topic, o.data, o.attributes::text, o.ordering_key, o.attempts
+FROM outbox o
+WHERE o.next_attempt_at <= NOW() AND o.txid < txid_snapshot_xmin(txid_current_snapshot())
+AND (o.ordering_key = '' OR NOT EXISTS (
+	SELECT 1 FROM outbox p
+	WHERE p.topic = o.topic AND p.ordering_key = o.ordering_key AND (p.txid, p.id) < (o.txid, o.id)
+	AND p.next_attempt_at > NOW()
+))
+ORDER BY o.txid, o.id
+LIMIT $1;`, r.BatchSize)
+}
+
+// publish sends messages to their topics. Messages of the same topic are published in one call,
+// if the publisher reports per-message results, and one by one otherwise.
+func (r *Relay) publish(ctx context.Context, msgs []outboxMsg) pubsub.Results {
+	res := make(pubsub.Results, len(msgs))
+	byTopic := make(map[string][]int)
+	var topics []string
+	for i, m := range msgs {
+		if _, ok := byTopic[m.topic]; !ok {
+			topics = append(topics, m.topic)
+		}
+		byTopic[m.topic] = append(byTopic[m.topic], i)
+	}
+	for _, topic := range topics {
+		index := byTopic[topic]
+		p := r.pubs[topic]
+		if p == nil {
+			for _, i := range index {
+				res[i].Err = fmt.Errorf("no publisher for topic %q", topic)
+			}
+			continue
+		}
+		list := make([]*pubsub.Message, 0, len(index))
+		for _, i := range index {
+			msg := msgs[i].msg
+			list = append(list, &msg)
+		}
+		for j, pr := range publishResults(ctx, p, list) {
+			res[index[j]] = pr
+		}
+	}
+	return res
+}
+
+// publishResults is similar to pubsub.PublishWithResults, but publishes messages one by one
+// if the publisher doesn't report per-message results, so a single failure doesn't fail all messages.
+func publishResults(ctx context.Context, p pubsub.MinPublisher, msgs []*pubsub.Message) pubsub.Results {
+	if _, ok := p.(pubsub.ResultPublisher); ok {
+		return pubsub.PublishWithResults(ctx, p, msgs...)
+	}
+	res := make(pubsub.Results, len(msgs))
+	paused := make(map[string]struct{})
+	for i, m := range msgs {
+		if _, ok := paused[m.OrderingKey]; ok {
+			res[i].Err = pubsub.ErrOrderingKeyPaused
+			continue
+		}
+		if err := p.PublishMsg(ctx, m); err != nil {
+			res[i].Err = err
+			if m.OrderingKey != "" {
+				paused[m.OrderingKey] = struct{}{}
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,371 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+const (
+	// DefaultBatchMaxPending is the default max number of buffered messages in a batch.
+	DefaultBatchMaxPending = 1000
+	// DefaultBatchMaxBytes is the default max size of buffered messages in a batch.
+	DefaultBatchMaxBytes = 9 * 1024 * 1024 // request limit is 10MB
+	// DefaultBatchMaxLatency is the default max time a message stays in the buffer.
+	DefaultBatchMaxLatency = 10 * time.Millisecond
+	// DefaultBatchMaxOutstanding is the default max number of messages that are buffered or being published.
+	DefaultBatchMaxOutstanding = 10 * DefaultBatchMaxPending
+)
+
+// ErrBatchClosed is returned when messages are published to a closed batch.
+var ErrBatchClosed = errors.New("batch is closed")
+
+// CloseMode defines what happens with buffered messages when the batch is closed.
+type CloseMode int
+
+const (
+	// CloseWait publishes buffered messages and waits for the result. It's the default.
+	CloseWait = CloseMode(iota)
+	// CloseCancel drops buffered messages and cancels flushes that are in progress.
+	CloseCancel
+)
+
+// BatchSettings controls buffering of messages in a batch.
+type BatchSettings struct {
+	// MaxPending is the max number of buffered messages. The batch is flushed when it's reached.
+	// DefaultBatchMaxPending is used if it's not positive.
+	MaxPending int
+	// MaxBytes is the max total size of buffered messages. The batch is flushed when it's reached.
+	// DefaultBatchMaxBytes is used if it's not positive.
+	MaxBytes int
+	// MaxLatency is the max time a message can stay in the buffer. Zero means no limit.
+	MaxLatency time.Duration
+	// MaxOutstanding is the max number of messages that are buffered or being published.
+	// PublishMsg blocks when it's reached, until some messages are published.
+	// DefaultBatchMaxOutstanding is used if it's not positive, and it's never less than MaxPending.
+	MaxOutstanding int
+	// CloseMode defines what happens with buffered messages on Close.
+	CloseMode CloseMode
+}
+
+// BatchOption changes batch settings.
+type BatchOption func(s *BatchSettings)
+
+// WithMaxPending sets the max number of buffered messages.
+func WithMaxPending(n int) BatchOption {
+	return func(s *BatchSettings) {
+		s.MaxPending = n
+	}
+}
+
+// WithMaxBytes sets the max total size of buffered messages.
+func WithMaxBytes(n int) BatchOption {
+	return func(s *BatchSettings) {
+		s.MaxBytes = n
+	}
+}
+
+// WithMaxLatency sets the max time a message can stay in the buffer.
+func WithMaxLatency(d time.Duration) BatchOption {
+	return func(s *BatchSettings) {
+		s.MaxLatency = d
+	}
+}
+
+// WithMaxOutstanding sets the max number of messages that are buffered or being published.
+func WithMaxOutstanding(n int) BatchOption {
+	return func(s *BatchSettings) {
+		s.MaxOutstanding = n
+	}
+}
+
+// WithCloseMode sets the behavior of Close.
+func WithCloseMode(m CloseMode) BatchOption {
+	return func(s *BatchSettings) {
+		s.CloseMode = m
+	}
+}
+
+func newBatchSettings(opts []BatchOption) BatchSettings {
+	s := BatchSettings{
+		MaxPending:     DefaultBatchMaxPending,
+		MaxBytes:       DefaultBatchMaxBytes,
+		MaxLatency:     DefaultBatchMaxLatency,
+		MaxOutstanding: DefaultBatchMaxOutstanding,
+	}
+	for _, o := range opts {
+		o(&s)
+	}
+	if s.MaxPending <= 0 {
+		s.MaxPending = DefaultBatchMaxPending
+	}
+	if s.MaxBytes <= 0 {
+		s.MaxBytes = DefaultBatchMaxBytes
+	}
+	if s.MaxLatency < 0 {
+		s.MaxLatency = 0
+	}
+	if s.MaxOutstanding <= 0 {
+		s.MaxOutstanding = DefaultBatchMaxOutstanding
+	}
+	if s.MaxOutstanding < s.MaxPending {
+		s.MaxOutstanding = s.MaxPending
+	}
+	return s
+}
+
+// messageSize estimates the size of the message on the wire.
+func messageSize(m *Message) int {
+	n := len(m.Data) + len(m.OrderingKey)
+	for k, v := range m.Attrs {
+		n += len(k) + len(v)
+	}
+	return n
+}
+
+// NewBatch creates a batch that buffers messages and publishes them to p in the background.
+// The batch is flushed automatically when one of the limits in settings is reached.
+//
+// Flushes run one at a time in the order messages were added, so the order of messages
+// with the same ordering key is preserved. Results of automatic flushes are returned by the next
+// Flush or FlushResults call.
+//
+// If a message with an ordering key fails, the following messages with the same key are not published
+// and fail with ErrOrderingKeyPaused, until the failure is returned by the next flush.
+func NewBatch(p MinPublisher, opts ...BatchOption) Batch {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &bufBatch{
+		p:        p,
+		set:      newBatchSettings(opts),
+		ctx:      ctx,
+		cancel:   cancel,
+		progress: make(chan struct{}),
+	}
+}
+
+type bufBatch struct {
+	p   MinPublisher
+	set BatchSettings
+
+	// ctx is used for all flushes, and is cancelled on Close
+	ctx    context.Context
+	cancel func()
+
+	mu          sync.Mutex
+	buf         []*Message
+	size        int
+	timer       *time.Timer
+	queue       [][]*Message        // chunks waiting to be published
+	outstanding int                 // messages in buf, queue and the current flush
+	flushing    bool                // the flush goroutine is running
+	queued      int                 // total number of messages moved to the queue
+	published   int                 // total number of queued messages that were published or failed
+	progress    chan struct{}       // closed and replaced when a chunk is published
+	results     Results             // results of published messages that were not returned by FlushResults yet
+	paused      map[string]struct{} // ordering keys that failed since the last flush
+	closed      bool
+}
+
+func (b *bufBatch) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return b.PublishMsg(ctx, list...)
+}
+
+// wait releases the lock until the next chunk is published or ctx is cancelled.
+// It must be called with the lock held.
+func (b *bufBatch) wait(ctx context.Context) error {
+	ch := b.progress
+	b.mu.Unlock()
+	defer b.mu.Lock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-ch:
+		return nil
+	}
+}
+
+// PublishMsg adds messages to the buffer. It doesn't wait for messages to be published,
+// unless the number of outstanding messages reaches MaxOutstanding.
+func (b *bufBatch) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, m := range msgs {
+		for !b.closed && b.outstanding >= b.set.MaxOutstanding {
+			if err := b.wait(ctx); err != nil {
+				return err
+			}
+		}
+		if b.closed {
+			return ErrBatchClosed
+		}
+		b.buf = append(b.buf, m)
+		b.size += messageSize(m)
+		b.outstanding++
+		if len(b.buf) >= b.set.MaxPending || (b.set.MaxBytes > 0 && b.size >= b.set.MaxBytes) {
+			b.enqueueLocked()
+		}
+	}
+	if len(b.buf) != 0 && b.timer == nil && b.set.MaxLatency > 0 {
+		b.timer = time.AfterFunc(b.set.MaxLatency, b.flushTimer)
+	}
+	return nil
+}
+
+func (b *bufBatch) flushTimer() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.timer = nil
+	if !b.closed {
+		b.enqueueLocked()
+	}
+}
+
+// enqueueLocked moves buffered messages to the publish queue and starts the flush goroutine, if needed.
+// It must be called with the lock held.
+func (b *bufBatch) enqueueLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if len(b.buf) == 0 {
+		return
+	}
+	b.queue = append(b.queue, b.buf)
+	b.queued += len(b.buf)
+	b.buf, b.size = nil, 0
+	if !b.flushing {
+		b.flushing = true
+		go b.flushLoop()
+	}
+}
+
+// flushLoop publishes queued chunks one by one without holding the lock.
+func (b *bufBatch) flushLoop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.queue) != 0 {
+		msgs := b.queue[0]
+		b.queue = b.queue[1:]
+		res := make(Results, len(msgs))
+		list := make([]*Message, 0, len(msgs))
+		index := make([]int, 0, len(msgs))
+		for i, m := range msgs {
+			if _, ok := b.paused[m.OrderingKey]; ok {
+				res[i].Err = ErrOrderingKeyPaused
+				continue
+			}
+			list = append(list, m)
+			index = append(index, i)
+		}
+		b.mu.Unlock()
+		if len(list) != 0 {
+			for j, r := range PublishWithResults(b.ctx, b.p, list...) {
+				res[index[j]] = r
+			}
+		}
+		b.mu.Lock()
+		for i, r := range res {
+			if key := msgs[i].OrderingKey; r.Err != nil && key != "" {
+				if b.paused == nil {
+					b.paused = make(map[string]struct{})
+				}
+				b.paused[key] = struct{}{}
+			}
+		}
+		b.results = append(b.results, res...)
+		b.outstanding -= len(msgs)
+		b.published += len(msgs)
+		close(b.progress)
+		b.progress = make(chan struct{})
+	}
+	b.flushing = false
+	// the queue might be dropped by Close while waiting for the lock
+	close(b.progress)
+	b.progress = make(chan struct{})
+}
+
+// Flush publishes buffered messages and waits until all messages added before the call are published.
+func (b *bufBatch) Flush(ctx context.Context) error {
+	res, err := b.FlushResults(ctx)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+// FlushResults publishes buffered messages and waits until all messages added before the call are published.
+// It returns results for all messages added since the last flush, in the order they were added.
+//
+// Once a message with an ordering key fails, the following messages with the same key fail with
+// ErrOrderingKeyPaused, until the failure is returned by a flush.
+func (b *bufBatch) FlushResults(ctx context.Context) (Results, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.enqueueLocked()
+	target := b.queued
+	for !b.closed && b.published < target {
+		if err := b.wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	// failures were reported to the caller, so publishing is resumed
+	b.paused = nil
+	return b.takeResultsLocked(target), nil
+}
+
+// takeResultsLocked removes results of messages up to a given number of queued messages.
+// Results of later messages are kept for the next flush. It must be called with the lock held.
+func (b *bufBatch) takeResultsLocked(target int) Results {
+	n := len(b.results) - (b.published - target)
+	if n < 0 || n > len(b.results) {
+		n = len(b.results)
+	}
+	res := b.results[:n:n]
+	b.results = b.results[n:]
+	return res
+}
+
+func (b *bufBatch) Close() error {
+	if b.set.CloseMode == CloseWait {
+		err := b.Flush(b.ctx)
+		b.mu.Lock()
+		b.closed = true
+		b.mu.Unlock()
+		b.cancel()
+		return err
+	}
+	b.cancel()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	dropped := len(b.buf)
+	for _, msgs := range b.queue {
+		dropped += len(msgs)
+	}
+	if dropped != 0 {
+		report.Message(context.Background(), "dropped %d events", dropped)
+	}
+	b.outstanding -= dropped
+	b.buf, b.size, b.queue = nil, 0, nil
+	// wake up blocked publishers
+	close(b.progress)
+	b.progress = make(chan struct{})
+	// wait for the cancelled flush to collect its error
+	for b.flushing {
+		_ = b.wait(context.Background())
+	}
+	res := b.results
+	b.results = nil
+	return res.Err()
+}
@@ -0,0 +1,224 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// failPublisher fails to publish messages with specific data.
+type failPublisher struct {
+	mu    sync.Mutex
+	p     *MemPublisher
+	fail  map[string]bool
+	calls int
+}
+
+func (p *failPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	var errs []error
+	for _, m := range msgs {
+		if p.fail[string(m.Data)] {
+			errs = append(errs, errors.New("failed: "+string(m.Data)))
+			continue
+		}
+		if err := p.p.PublishMsg(ctx, m); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (p *failPublisher) getCalls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.calls
+}
+
+func TestBatchMaxPending(t *testing.T) {
+	ctx := context.Background()
+	p := &failPublisher{p: NewMemPublisher(), fail: map[string]bool{"b": true, "d": true}}
+	b := NewBatch(p, WithMaxPending(2), WithMaxLatency(0))
+
+	require.NoError(t, b.Publish(ctx, []byte("a")))
+	require.Empty(t, p.p.GetEvents())
+	require.NoError(t, b.Publish(ctx, []byte("b"), []byte("c")))
+	require.Eventually(t, func() bool {
+		return p.getCalls() == 1
+	}, time.Second, time.Millisecond)
+	require.Len(t, p.p.GetEvents(), 1)
+	require.NoError(t, b.Publish(ctx, []byte("d")))
+
+	err := b.Flush(ctx)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "failed: b")
+	require.Contains(t, err.Error(), "failed: d")
+	require.NoError(t, b.Flush(ctx))
+	require.NoError(t, b.Close())
+	require.Error(t, b.Publish(ctx, []byte("e")))
+}
+
+func TestBatchMaxBytes(t *testing.T) {
+	ctx := context.Background()
+	p := NewMemPublisher()
+	b := NewBatch(p, WithMaxBytes(10), WithMaxLatency(0))
+
+	require.NoError(t, b.Publish(ctx, []byte("12345")))
+	require.Empty(t, p.GetEvents())
+	require.NoError(t, b.Publish(ctx, []byte("67890")))
+	require.Eventually(t, func() bool {
+		return len(p.GetEvents()) == 2
+	}, time.Second, time.Millisecond)
+}
+
+func TestBatchMaxLatency(t *testing.T) {
+	ctx := context.Background()
+	p := &failPublisher{p: NewMemPublisher()}
+	b := NewBatch(p, WithMaxLatency(10*time.Millisecond))
+
+	require.NoError(t, b.Publish(ctx, []byte("a"), []byte("b")))
+	require.Eventually(t, func() bool {
+		return p.getCalls() == 1
+	}, time.Second, time.Millisecond)
+	require.Len(t, p.p.GetEvents(), 2)
+	require.NoError(t, b.Close())
+}
+
+func TestBatchClose(t *testing.T) {
+	ctx := context.Background()
+
+	p := NewMemPublisher()
+	b := NewBatch(p, WithCloseMode(CloseCancel), WithMaxLatency(0))
+	require.NoError(t, b.Publish(ctx, []byte("a")))
+	require.NoError(t, b.Close())
+	require.Empty(t, p.GetEvents())
+
+	// nothing is dropped by default
+	b = NewBatch(p)
+	require.NoError(t, b.Publish(ctx, []byte("a")))
+	require.NoError(t, b.Close())
+	require.Len(t, p.GetEvents(), 1)
+}
+
+// blockingPublisher blocks until it's released.
+type blockingPublisher struct {
+	p       *MemPublisher
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingPublisher() *blockingPublisher {
+	return &blockingPublisher{p: NewMemPublisher(), started: make(chan struct{}, 100), release: make(chan struct{})}
+}
+
+func (p *blockingPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	p.started <- struct{}{}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.release:
+	}
+	return p.p.PublishMsg(ctx, msgs...)
+}
+
+func TestBatchAsync(t *testing.T) {
+	ctx := context.Background()
+	p := newBlockingPublisher()
+	b := NewBatch(p, WithMaxPending(2), WithMaxOutstanding(4), WithMaxLatency(0))
+
+	// publishing doesn't wait for the flush
+	require.NoError(t, b.Publish(ctx, []byte("1"), []byte("2"), []byte("3"), []byte("4")))
+
+	// until there are too many outstanding messages
+	tctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.Publish(tctx, []byte("5")), context.DeadlineExceeded)
+
+	fctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	require.ErrorIs(t, b.Flush(fctx), context.DeadlineExceeded)
+
+	close(p.release)
+	require.NoError(t, b.Publish(ctx, []byte("5")))
+	require.NoError(t, b.Flush(ctx))
+	var data []string
+	for _, m := range p.p.GetEvents() {
+		data = append(data, string(m.Data))
+	}
+	require.Equal(t, []string{"1", "2", "3", "4", "5"}, data)
+	require.NoError(t, b.Close())
+}
+
+func TestBatchCloseCancel(t *testing.T) {
+	ctx := context.Background()
+	p := newBlockingPublisher()
+	b := NewBatch(p, WithMaxPending(1), WithCloseMode(CloseCancel), WithMaxLatency(0))
+	require.NoError(t, b.Publish(ctx, []byte("1"), []byte("2")))
+	<-p.started
+
+	// the flush in progress is cancelled and queued messages are dropped
+	err := b.Close()
+	require.ErrorIs(t, err, context.Canceled)
+	require.Empty(t, p.p.GetEvents())
+}
+
+func TestBatchFlushResults(t *testing.T) {
+	ctx := context.Background()
+	p := NewMemPublisher()
+	errFail := errors.New("fail")
+	p.FailAt(errFail, 1, 3)
+
+	// messages are split into several internal flushes
+	b := NewBatch(p, WithMaxPending(2), WithMaxLatency(0))
+	for _, data := range []string{"0", "1", "2", "3", "4"} {
+		require.NoError(t, b.Publish(ctx, []byte(data)))
+	}
+	res, err := b.FlushResults(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Results{
+		{ID: "0"},
+		{Err: errFail},
+		{ID: "2"},
+		{Err: errFail},
+		{ID: "4"},
+	}, res)
+
+	// indexes are in the order of messages added to the batch
+	var perr *PublishError
+	require.ErrorAs(t, res.Err(), &perr)
+	require.Equal(t, 1, perr.Index)
+
+	// results are reset after the flush
+	require.NoError(t, b.Publish(ctx, []byte("5")))
+	res, err = b.FlushResults(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Results{{ID: "5"}}, res)
+	require.NoError(t, b.Close())
+}
+
+func TestBatchSettings(t *testing.T) {
+	s := newBatchSettings([]BatchOption{WithMaxPending(0), WithMaxBytes(-1), WithMaxOutstanding(0), WithMaxLatency(-1)})
+	require.Equal(t, BatchSettings{
+		MaxPending:     DefaultBatchMaxPending,
+		MaxBytes:       DefaultBatchMaxBytes,
+		MaxOutstanding: DefaultBatchMaxOutstanding,
+	}, s)
+
+	s = newBatchSettings([]BatchOption{WithMaxPending(10), WithMaxOutstanding(5)})
+	require.Equal(t, 10, s.MaxOutstanding)
+
+	// invalid limits don't block publishing
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	p := NewMemPublisher()
+	b := NewBatch(p, WithMaxPending(0), WithMaxOutstanding(0))
+	require.NoError(t, b.Publish(ctx, []byte("1")))
+	require.NoError(t, b.Close())
+	require.Len(t, p.GetEvents(), 1)
+}
@@ -0,0 +1,66 @@
+package gcs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+
+	"cloud.google.com/go/storage"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+var _ pubsub.BlobStore = (*Store)(nil)
+
+// Store keeps message payloads in a GCS bucket.
+//
+// Blobs are not deleted, configure a lifecycle rule on the bucket to expire them.
+type Store struct {
+	bucket string
+	cli    *storage.Client
+}
+
+// NewStoreFromEnv creates a store for a bucket set in PUBSUB_BLOB_BUCKET.
+func NewStoreFromEnv(ctx context.Context) (*Store, error) {
+	bucket := os.Getenv("PUBSUB_BLOB_BUCKET")
+	if bucket == "" {
+		return nil, errors.New("PUBSUB_BLOB_BUCKET was not set")
+	}
+	return NewStore(ctx, bucket)
+}
+
+func NewStore(ctx context.Context, bucket string) (*Store, error) {
+	storageCli, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		bucket: bucket,
+		cli:    storageCli,
+	}, nil
+}
+
+func (s *Store) PutBlob(ctx context.Context, key string, data []byte) error {
+	w := s.cli.Bucket(s.bucket).Object(key).NewWriter(ctx)
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func (s *Store) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	r, err := s.cli.Bucket(s.bucket).Object(key).NewReader(ctx)
+	if err == storage.ErrBucketNotExist || err == storage.ErrObjectNotExist {
+		return nil, dbs.ErrNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+func (s *Store) Close() error { return s.cli.Close() }
@@ -0,0 +1,40 @@
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+var _ pubsub.BlobStore = (*Store)(nil)
+
+// Store keeps message payloads in a local directory. It's useful for testing.
+type Store struct {
+	workDir string
+}
+
+func New(workDir string) *Store {
+	return &Store{
+		workDir: workDir,
+	}
+}
+
+func (s *Store) PutBlob(ctx context.Context, key string, data []byte) error {
+	if err := os.MkdirAll(s.workDir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.workDir, filepath.Base(key)), data, 0o644)
+}
+
+func (s *Store) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(s.workDir, filepath.Base(key)))
+	if os.IsNotExist(err) {
+		return nil, dbs.ErrNotFound
+	}
+	return data, err
+}
+
+func (s *Store) Close() error { return nil }
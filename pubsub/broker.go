@@ -0,0 +1,479 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultAckDeadline is the default time given to the handler to acknowledge the message.
+	DefaultAckDeadline = 10 * time.Second
+
+	// AttrDeadLetterSubscription is set on dead-lettered messages to the name of the source subscription.
+	AttrDeadLetterSubscription = "CloudPubSubDeadLetterSourceSubscription"
+	// AttrDeadLetterDeliveryCount is set on dead-lettered messages to the number of delivery attempts.
+	AttrDeadLetterDeliveryCount = "CloudPubSubDeadLetterSourceDeliveryCount"
+)
+
+// SubscriptionConfig contains settings for subscriptions created by MemBroker or provisioned in Pub/Sub.
+type SubscriptionConfig struct {
+	// AckDeadline is the time the handler has to process the message.
+	// If the handler takes longer, the message is redelivered, regardless of the result.
+	// DefaultAckDeadline is used if not set.
+	AckDeadline time.Duration `yaml:"ack_deadline"`
+	// RetryDelay is a delay before redelivering messages that were not acknowledged.
+	RetryDelay time.Duration `yaml:"retry_delay"`
+	// DeadLetterTopic is a topic where messages are sent after MaxDeliveryAttempts.
+	// If not set, messages are redelivered indefinitely.
+	DeadLetterTopic string `yaml:"dead_letter_topic"`
+	// MaxDeliveryAttempts is the number of delivery attempts before sending the message to the DeadLetterTopic.
+	MaxDeliveryAttempts int `yaml:"max_delivery_attempts"`
+	// Concurrency limits the number of messages processed at the same time.
+	// DefaultConcurrency is used if not set.
+	Concurrency int `yaml:"concurrency"`
+	// EnableMessageOrdering enables ordered delivery of messages with the same ordering key.
+	// The next message for the key is delivered only after the previous one is acknowledged or dead-lettered.
+	EnableMessageOrdering bool `yaml:"enable_message_ordering"`
+}
+
+// NewMemBroker creates an in-memory Pub/Sub broker that is useful for testing.
+func NewMemBroker() *MemBroker {
+	return &MemBroker{
+		topics:  make(map[string]*memTopic),
+		changed: make(chan struct{}),
+	}
+}
+
+// MemBroker is an in-memory Pub/Sub implementation with topics and subscriptions.
+//
+// Messages published to a topic are delivered to all subscriptions of this topic
+// that existed at the time of publishing.
+type MemBroker struct {
+	mu      sync.Mutex
+	topics  map[string]*memTopic
+	pending int // queued and in-flight messages in all subscriptions
+	lastID  uint64
+	changed chan struct{}
+}
+
+type memTopic struct {
+	name string
+	subs map[string]*MemSubscription
+}
+
+func (b *MemBroker) topic(name string) *memTopic {
+	t := b.topics[name]
+	if t == nil {
+		t = &memTopic{name: name, subs: make(map[string]*MemSubscription)}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// addPending must be called with the lock held.
+func (b *MemBroker) addPending(n int) {
+	b.pending += n
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// Publisher returns a publisher for a given topic. The topic is created if it doesn't exist.
+func (b *MemBroker) Publisher(topic string) Publisher {
+	b.mu.Lock()
+	b.topic(topic)
+	b.mu.Unlock()
+	return &memBrokerPublisher{b: b, topic: topic}
+}
+
+// Subscribe creates a new subscription for a given topic. The topic is created if it doesn't exist.
+func (b *MemBroker) Subscribe(topic, name string, conf SubscriptionConfig) (*MemSubscription, error) {
+	if conf.AckDeadline <= 0 {
+		conf.AckDeadline = DefaultAckDeadline
+	}
+	if conf.Concurrency <= 0 {
+		conf.Concurrency = DefaultConcurrency
+	}
+	if conf.DeadLetterTopic != "" && conf.MaxDeliveryAttempts <= 0 {
+		return nil, errors.New("max delivery attempts must be set for dead letter topic")
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range b.topics {
+		if _, ok := t.subs[name]; ok {
+			return nil, fmt.Errorf("subscription already exists: %q", name)
+		}
+	}
+	if conf.DeadLetterTopic != "" {
+		b.topic(conf.DeadLetterTopic)
+	}
+	t := b.topic(topic)
+	s := &MemSubscription{
+		b:      b,
+		name:   name,
+		conf:   conf,
+		notify: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+		busy:   make(map[string]struct{}),
+	}
+	t.subs[name] = s
+	return s, nil
+}
+
+// Wait blocks until all messages in all subscriptions are acknowledged or dead-lettered.
+// It's useful to wait for a pipeline of handlers to finish processing.
+func (b *MemBroker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		pending, changed := b.pending, b.changed
+		b.mu.Unlock()
+		if pending == 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (b *MemBroker) publish(topic string, msgs ...*Message) error {
+	list := make([]Message, 0, len(msgs))
+	for _, m := range msgs {
+		msg, err := copyMessage(m)
+		if err != nil {
+			return err
+		}
+		list = append(list, msg)
+	}
+	now := time.Now()
+	b.mu.Lock()
+	t := b.topic(topic)
+	subs := make([]*MemSubscription, 0, len(t.subs))
+	for _, s := range t.subs {
+		subs = append(subs, s)
+	}
+	ids := make([]string, 0, len(list))
+	for range list {
+		b.lastID++
+		ids = append(ids, strconv.FormatUint(b.lastID, 10))
+	}
+	b.addPending(len(subs) * len(list))
+	b.mu.Unlock()
+	for _, s := range subs {
+		s.push(list, ids, now)
+	}
+	return nil
+}
+
+var _ Publisher = (*memBrokerPublisher)(nil)
+
+type memBrokerPublisher struct {
+	b     *MemBroker
+	topic string
+}
+
+// Publish messages to the topic.
+func (p *memBrokerPublisher) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return p.b.publish(p.topic, list...)
+}
+
+// PublishMsg publishes messages to the topic.
+func (p *memBrokerPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return p.b.publish(p.topic, msgs...)
+}
+
+// Batch creates a batch that publishes messages to the topic immediately. Options are ignored.
+func (p *memBrokerPublisher) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
+	return &memBatch{p: p}, nil
+}
+
+var _ Subscriber = (*MemSubscription)(nil)
+
+// MemSubscription is a subscription in MemBroker.
+//
+// Unlike MemSubscriber, it redelivers messages that were not acknowledged in time
+// and sends them to a dead letter topic, if configured.
+type MemSubscription struct {
+	b      *MemBroker
+	name   string
+	conf   SubscriptionConfig
+	notify chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu     sync.Mutex
+	queue  []*memDelivery
+	busy   map[string]struct{} // ordering keys with in-flight messages
+	acked  []Message
+	dead   []Message
+	active bool
+}
+
+type memDelivery struct {
+	msg       Message
+	id        string
+	published time.Time
+	attempts  int
+	notBefore time.Time
+}
+
+// Name returns the subscription name.
+func (s *MemSubscription) Name() string {
+	return s.name
+}
+
+// GetAcked gets all acknowledged messages and clears the list.
+func (s *MemSubscription) GetAcked() []Message {
+	s.mu.Lock()
+	msgs := s.acked
+	s.acked = nil
+	s.mu.Unlock()
+	return msgs
+}
+
+// GetDeadLettered gets all messages sent to the dead letter topic and clears the list.
+func (s *MemSubscription) GetDeadLettered() []Message {
+	s.mu.Lock()
+	msgs := s.dead
+	s.dead = nil
+	s.mu.Unlock()
+	return msgs
+}
+
+func (s *MemSubscription) wakeup() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (s *MemSubscription) push(msgs []Message, ids []string, published time.Time) {
+	s.mu.Lock()
+	for i, m := range msgs {
+		s.queue = append(s.queue, &memDelivery{msg: m, id: ids[i], published: published})
+	}
+	s.mu.Unlock()
+	s.wakeup()
+}
+
+// next returns the next message that is ready for delivery.
+// If there is none, it returns the time when the next message becomes ready, if any.
+func (s *MemSubscription) next(now time.Time) (*memDelivery, time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var (
+		next    time.Time
+		blocked map[string]struct{}
+	)
+	for i, d := range s.queue {
+		key := s.orderingKey(d)
+		if key != "" {
+			if _, ok := s.busy[key]; ok {
+				continue
+			} else if _, ok = blocked[key]; ok {
+				continue
+			}
+		}
+		if !d.notBefore.After(now) {
+			s.queue = append(s.queue[:i:i], s.queue[i+1:]...)
+			if key != "" {
+				s.busy[key] = struct{}{}
+			}
+			return d, time.Time{}
+		}
+		if next.IsZero() || d.notBefore.Before(next) {
+			next = d.notBefore
+		}
+		if key != "" {
+			// following messages for this key must wait for this one
+			if blocked == nil {
+				blocked = make(map[string]struct{})
+			}
+			blocked[key] = struct{}{}
+		}
+	}
+	return nil, next
+}
+
+// orderingKey returns the ordering key of the message, if ordering is enabled.
+func (s *MemSubscription) orderingKey(d *memDelivery) string {
+	if !s.conf.EnableMessageOrdering {
+		return ""
+	}
+	return d.msg.OrderingKey
+}
+
+// release marks the ordering key of the message as not busy.
+// It must be called with the lock held.
+func (s *MemSubscription) release(d *memDelivery) {
+	if key := s.orderingKey(d); key != "" {
+		delete(s.busy, key)
+	}
+}
+
+// requeue schedules the message for redelivery, or sends it to the dead letter topic.
+func (s *MemSubscription) requeue(ctx context.Context, d *memDelivery) {
+	if s.conf.DeadLetterTopic != "" && d.attempts >= s.conf.MaxDeliveryAttempts {
+		attrs := make(map[string]string, len(d.msg.Attrs)+2)
+		for k, v := range d.msg.Attrs {
+			attrs[k] = v
+		}
+		attrs[AttrDeadLetterSubscription] = s.name
+		attrs[AttrDeadLetterDeliveryCount] = strconv.Itoa(d.attempts)
+		// cannot fail, since the message was already copied
+		_ = s.b.publish(s.conf.DeadLetterTopic, &Message{Data: d.msg.Data, Attrs: attrs, OrderingKey: d.msg.OrderingKey})
+		s.mu.Lock()
+		s.dead = append(s.dead, d.msg)
+		s.release(d)
+		s.mu.Unlock()
+		s.done()
+		return
+	}
+	d.notBefore = time.Now().Add(s.conf.RetryDelay)
+	s.mu.Lock()
+	if s.orderingKey(d) != "" {
+		// must be redelivered before any other message with the same key
+		s.queue = append([]*memDelivery{d}, s.queue...)
+	} else {
+		s.queue = append(s.queue, d)
+	}
+	s.release(d)
+	s.mu.Unlock()
+	s.wakeup()
+}
+
+// done marks the message as processed.
+func (s *MemSubscription) done() {
+	s.b.mu.Lock()
+	s.b.addPending(-1)
+	s.b.mu.Unlock()
+	s.wakeup()
+}
+
+func (s *MemSubscription) deliver(ctx context.Context, h Handler, d *memDelivery) {
+	d.attempts++
+	start := time.Now()
+	hctx := WithMetadata(ctx, Metadata{
+		Subscription:    s.name,
+		ID:              d.id,
+		PublishTime:     d.published,
+		DeliveryAttempt: d.attempts,
+		OrderingKey:     d.msg.OrderingKey,
+	})
+	hctx, cancel := context.WithTimeout(hctx, s.conf.AckDeadline)
+	ack := handleMessage(hctx, h, d.msg)
+	cancel()
+	if ack && time.Since(start) < s.conf.AckDeadline {
+		s.mu.Lock()
+		s.acked = append(s.acked, d.msg)
+		s.release(d)
+		s.mu.Unlock()
+		s.done()
+		return
+	}
+	s.requeue(ctx, d)
+}
+
+// Receive delivers messages to the handler. Only one Receive call can be active at a time.
+//
+// It blocks until the context is cancelled or the subscription is closed. In the latter case,
+// it returns only after all messages are acknowledged or dead-lettered.
+func (s *MemSubscription) Receive(ctx context.Context, h Handler) error {
+	s.mu.Lock()
+	if s.active {
+		s.mu.Unlock()
+		return fmt.Errorf("subscription %q is already active", s.name)
+	}
+	s.active = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active = false
+		s.mu.Unlock()
+	}()
+
+	var (
+		wg       sync.WaitGroup
+		sem      = make(chan struct{}, s.conf.Concurrency)
+		inflight int32
+		mu       sync.Mutex
+	)
+	defer wg.Wait()
+	closing := false
+	for {
+		d, next := s.next(time.Now())
+		if d == nil {
+			mu.Lock()
+			idle := inflight == 0 && next.IsZero()
+			mu.Unlock()
+			if closing && idle {
+				return nil
+			}
+			closed := s.closed
+			if closing {
+				closed = nil
+			}
+			var timer *time.Timer
+			if next.IsZero() {
+				// never fires
+				timer = time.NewTimer(time.Hour)
+				timer.Stop()
+			} else {
+				timer = time.NewTimer(time.Until(next))
+			}
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-closed:
+				closing = true
+			case <-s.notify:
+			case <-timer.C:
+			}
+			timer.Stop()
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			s.mu.Lock()
+			s.queue = append([]*memDelivery{d}, s.queue...)
+			s.release(d)
+			s.mu.Unlock()
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+		mu.Lock()
+		inflight++
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer func() {
+				mu.Lock()
+				inflight--
+				mu.Unlock()
+				<-sem
+				wg.Done()
+				s.wakeup()
+			}()
+			s.deliver(ctx, h, d)
+		}()
+	}
+}
+
+// Close the subscription. Receive will return after processing all pending messages.
+func (s *MemSubscription) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
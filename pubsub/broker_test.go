@@ -0,0 +1,165 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemBrokerFanOut(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBroker()
+
+	s1, err := b.Subscribe("topic", "sub1", SubscriptionConfig{})
+	require.NoError(t, err)
+	s2, err := b.Subscribe("topic", "sub2", SubscriptionConfig{})
+	require.NoError(t, err)
+	_, err = b.Subscribe("topic", "sub2", SubscriptionConfig{})
+	require.Error(t, err)
+
+	p := b.Publisher("topic")
+	require.NoError(t, p.Publish(ctx, []byte("a"), []byte("b")))
+
+	batch, err := p.Batch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, batch.PublishMsg(ctx, &Message{Data: []byte("c")}))
+	require.NoError(t, batch.Flush(ctx))
+
+	for _, s := range []*MemSubscription{s1, s2} {
+		require.NoError(t, s.Close())
+		require.NoError(t, s.Receive(ctx, func(ctx context.Context, msg Message) error {
+			return nil
+		}))
+		require.Len(t, s.GetAcked(), 3)
+	}
+	require.NoError(t, b.Wait(ctx))
+}
+
+func TestMemBrokerRedelivery(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBroker()
+
+	s, err := b.Subscribe("topic", "sub", SubscriptionConfig{
+		AckDeadline: 50 * time.Millisecond,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publisher("topic").Publish(ctx, []byte("a")))
+	require.NoError(t, s.Close())
+
+	var calls int32
+	err = s.Receive(ctx, func(ctx context.Context, msg Message) error {
+		n := atomic.AddInt32(&calls, 1)
+		md, ok := GetMetadata(ctx)
+		require.True(t, ok)
+		require.Equal(t, "sub", md.Subscription)
+		require.Equal(t, "1", md.ID)
+		require.Equal(t, int(n), md.DeliveryAttempt)
+		switch n {
+		case 1:
+			return errors.New("nack")
+		case 2:
+			// exceed the ack deadline
+			<-ctx.Done()
+			return nil
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Len(t, s.GetAcked(), 1)
+}
+
+func TestMemBrokerDeadLetter(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBroker()
+
+	s, err := b.Subscribe("topic", "sub", SubscriptionConfig{
+		DeadLetterTopic:     "dead",
+		MaxDeliveryAttempts: 3,
+	})
+	require.NoError(t, err)
+	dead, err := b.Subscribe("dead", "dead-sub", SubscriptionConfig{})
+	require.NoError(t, err)
+
+	require.NoError(t, b.Publisher("topic").PublishMsg(ctx, &Message{
+		Data: []byte("a"), Attrs: map[string]string{"k": "v"},
+	}))
+	require.NoError(t, s.Close())
+
+	var calls int32
+	err = s.Receive(ctx, func(ctx context.Context, msg Message) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("fail")
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	require.Empty(t, s.GetAcked())
+	require.Len(t, s.GetDeadLettered(), 1)
+
+	require.NoError(t, dead.Close())
+	require.NoError(t, dead.Receive(ctx, func(ctx context.Context, msg Message) error {
+		return nil
+	}))
+	require.Equal(t, []Message{{
+		Data: []byte("a"),
+		Attrs: map[string]string{
+			"k":                         "v",
+			AttrDeadLetterSubscription:  "sub",
+			AttrDeadLetterDeliveryCount: "3",
+		},
+	}}, dead.GetAcked())
+	require.NoError(t, b.Wait(ctx))
+}
+
+func TestMemBrokerOrdering(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemBroker()
+
+	s, err := b.Subscribe("topic", "sub", SubscriptionConfig{
+		EnableMessageOrdering: true,
+		Concurrency:           4,
+	})
+	require.NoError(t, err)
+
+	p := b.Publisher("topic")
+	const n = 20
+	for i := 0; i < n; i++ {
+		for _, key := range []string{"a", "b"} {
+			err = p.PublishMsg(ctx, &Message{Data: []byte(strconv.Itoa(i)), OrderingKey: key})
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, s.Close())
+
+	var (
+		mu     sync.Mutex
+		failed = make(map[string]bool)
+		got    = make(map[string][]string)
+	)
+	err = s.Receive(ctx, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		id := msg.OrderingKey + string(msg.Data)
+		if string(msg.Data) == "5" && !failed[id] {
+			// following messages for this key must wait for redelivery
+			failed[id] = true
+			return errors.New("retry")
+		}
+		got[msg.OrderingKey] = append(got[msg.OrderingKey], string(msg.Data))
+		return nil
+	})
+	require.NoError(t, err)
+
+	var exp []string
+	for i := 0; i < n; i++ {
+		exp = append(exp, strconv.Itoa(i))
+	}
+	require.Equal(t, map[string][]string{"a": exp, "b": exp}, got)
+}
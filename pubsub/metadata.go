@@ -0,0 +1,51 @@
+package pubsub
+
+import (
+	"context"
+	"time"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+// Metadata contains delivery information for a received Pub/Sub message.
+type Metadata struct {
+	// Subscription is the full name of the subscription the message was received from.
+	Subscription string
+	// ID is a message ID assigned by the server.
+	ID string
+	// PublishTime is the time when the message was published.
+	PublishTime time.Time
+	// DeliveryAttempt is the number of delivery attempts for the message, starting from 1.
+	// It's zero if the subscription doesn't track delivery attempts.
+	DeliveryAttempt int
+	// OrderingKey is the ordering key of the message, if any.
+	OrderingKey string
+}
+
+type metadataKey struct{}
+
+// WithMetadata attaches message delivery information to the context.
+func WithMetadata(ctx context.Context, md Metadata) context.Context {
+	if md.Subscription != "" {
+		ctx = report.WithStringValue(ctx, "pubsub.subscription", md.Subscription)
+	}
+	if md.ID != "" {
+		ctx = report.WithStringValue(ctx, "pubsub.message_id", md.ID)
+	}
+	if !md.PublishTime.IsZero() {
+		ctx = report.WithStringValue(ctx, "pubsub.publish_time", md.PublishTime.UTC().Format(time.RFC3339Nano))
+	}
+	if md.DeliveryAttempt != 0 {
+		ctx = report.WithIntValue(ctx, "pubsub.delivery_attempt", md.DeliveryAttempt)
+	}
+	if md.OrderingKey != "" {
+		ctx = report.WithStringValue(ctx, "pubsub.ordering_key", md.OrderingKey)
+	}
+	return context.WithValue(ctx, metadataKey{}, md)
+}
+
+// GetMetadata returns message delivery information from the context, if any.
+func GetMetadata(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(metadataKey{}).(Metadata)
+	return md, ok
+}
@@ -0,0 +1,303 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+// PublishFunc publishes messages and returns per-message results.
+type PublishFunc func(ctx context.Context, msgs ...*Message) Results
+
+// PublisherMiddleware wraps the publish function to alter messages or observe results.
+//
+// Middlewares must not modify messages passed to them, since they are owned by the caller.
+type PublisherMiddleware func(next PublishFunc) PublishFunc
+
+var (
+	_ Publisher       = (*mwPublisher)(nil)
+	_ ResultPublisher = (*mwPublisher)(nil)
+)
+
+// WithMiddleware wraps the publisher with middlewares. The first middleware is the outermost one,
+// i.e. it sees messages first and results last.
+//
+// Batches of the returned publisher are buffered before passing messages to middlewares.
+func WithMiddleware(p MinPublisher, mws ...PublisherMiddleware) Publisher {
+	next := func(ctx context.Context, msgs ...*Message) Results {
+		return PublishWithResults(ctx, p, msgs...)
+	}
+	for i := len(mws) - 1; i >= 0; i-- {
+		next = mws[i](next)
+	}
+	return &mwPublisher{p: p, publish: next}
+}
+
+type mwPublisher struct {
+	p       MinPublisher
+	publish PublishFunc
+}
+
+// Close closes the underlying publisher.
+func (p *mwPublisher) Close() error {
+	return ClosePublisher(p.p)
+}
+
+// Check checks the underlying publisher.
+func (p *mwPublisher) Check(ctx context.Context) error {
+	return CheckPublisher(ctx, p.p)
+}
+
+func (p *mwPublisher) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return p.publish(ctx, list...).Err()
+}
+
+func (p *mwPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return p.publish(ctx, msgs...).Err()
+}
+
+func (p *mwPublisher) PublishResults(ctx context.Context, msgs ...*Message) Results {
+	return p.publish(ctx, msgs...)
+}
+
+func (p *mwPublisher) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
+	return NewBatch(p, opts...), nil
+}
+
+// AttrsMiddleware sets attributes returned by fn on all messages. Existing attributes are not overwritten.
+func AttrsMiddleware(fn func(ctx context.Context) map[string]string) PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, msgs ...*Message) Results {
+			attrs := fn(ctx)
+			if len(attrs) == 0 {
+				return next(ctx, msgs...)
+			}
+			list := make([]*Message, 0, len(msgs))
+			for _, m := range msgs {
+				out := *m
+				out.Attrs = make(map[string]string, len(m.Attrs)+len(attrs))
+				for k, v := range attrs {
+					out.Attrs[k] = v
+				}
+				for k, v := range m.Attrs {
+					out.Attrs[k] = v
+				}
+				list = append(list, &out)
+			}
+			return next(ctx, list...)
+		}
+	}
+}
+
+const (
+	labelTopic  = "topic"
+	labelStatus = "status"
+
+	statusOK    = "ok"
+	statusError = "error"
+)
+
+var (
+	countPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "athenian_pubsub_published_count",
+		Help: "The count of published Pub/Sub messages",
+	}, []string{labelTopic, labelStatus})
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "athenian_pubsub_publish_seconds",
+		Help:    "The duration of Pub/Sub publish calls",
+		Buckets: prometheus.DefBuckets,
+	}, []string{labelTopic})
+	publishSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "athenian_pubsub_message_bytes",
+		Help:    "The size of published Pub/Sub messages",
+		Buckets: prometheus.ExponentialBuckets(256, 4, 8),
+	}, []string{labelTopic})
+)
+
+// MetricsMiddleware records the count, size and publish latency of messages for the topic.
+func MetricsMiddleware(topic string) PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, msgs ...*Message) Results {
+			start := time.Now()
+			res := next(ctx, msgs...)
+			publishDuration.WithLabelValues(topic).Observe(time.Since(start).Seconds())
+			var ok, failed int
+			for i, r := range res {
+				if r.Err != nil {
+					failed++
+					continue
+				}
+				ok++
+				publishSize.WithLabelValues(topic).Observe(float64(messageSize(msgs[i])))
+			}
+			countPublished.WithLabelValues(topic, statusOK).Add(float64(ok))
+			countPublished.WithLabelValues(topic, statusError).Add(float64(failed))
+			return res
+		}
+	}
+}
+
+const (
+	// AttrContextPrefix is a prefix for attributes that carry report context values.
+	AttrContextPrefix = "com.athenian.ctx."
+	// maxAttrValue is the max size of the attribute value in Pub/Sub.
+	maxAttrValue = 1024
+	// reservedContextPrefix is a prefix of context values with Pub/Sub delivery information, see WithMetadata.
+	reservedContextPrefix = "pubsub."
+)
+
+// ContextAttrs encodes report context values as message attributes. If keys are given, only those values are encoded.
+//
+// Pub/Sub delivery information is not propagated, as well as values that do not fit into the attribute.
+// Only string, int64 and []string values are encoded, since RestoreContext cannot restore other types.
+func ContextAttrs(ctx context.Context, keys ...string) map[string]string {
+	var only map[string]struct{}
+	if len(keys) != 0 {
+		only = make(map[string]struct{}, len(keys))
+		for _, k := range keys {
+			only[k] = struct{}{}
+		}
+	}
+	attrs := make(map[string]string)
+	for k, v := range report.GetContextMap(ctx) {
+		if strings.HasPrefix(k, reservedContextPrefix) {
+			continue
+		}
+		if _, ok := only[k]; only != nil && !ok {
+			continue
+		}
+		switch v.(type) {
+		case string, int64, []string:
+		default:
+			continue
+		}
+		data, err := json.Marshal(v)
+		if err != nil || len(data) > maxAttrValue {
+			continue
+		}
+		attrs[AttrContextPrefix+k] = string(data)
+	}
+	return attrs
+}
+
+// ContextMiddleware propagates report context values into message attributes.
+// See ContextAttrs for details and RestoreContext for the receiving side.
+func ContextMiddleware(keys ...string) PublisherMiddleware {
+	return AttrsMiddleware(func(ctx context.Context) map[string]string {
+		return ContextAttrs(ctx, keys...)
+	})
+}
+
+// RestoreContext sets report context values encoded by ContextMiddleware in message attributes.
+//
+// Pub/Sub delivery information is never restored, thus it cannot be overwritten by the publisher.
+func RestoreContext(ctx context.Context, attrs map[string]string) context.Context {
+	for k, s := range attrs {
+		if !strings.HasPrefix(k, AttrContextPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(k, AttrContextPrefix)
+		if strings.HasPrefix(key, reservedContextPrefix) {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(s))
+		dec.UseNumber()
+		var v interface{}
+		if err := dec.Decode(&v); err != nil {
+			continue
+		}
+		switch v := v.(type) {
+		case string:
+			ctx = report.WithStringValue(ctx, key, v)
+		case json.Number:
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				ctx = report.WithInt64Value(ctx, key, n)
+			}
+		case []interface{}:
+			list := make([]string, 0, len(v))
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					list = append(list, s)
+				}
+			}
+			ctx = report.WithStringValues(ctx, key, list)
+		}
+	}
+	return ctx
+}
+
+// NewContextHandler wraps the handler to restore report context values from message attributes.
+func NewContextHandler(h Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		return h(RestoreContext(ctx, msg.Attrs), msg)
+	}
+}
+
+// AttrSentryTrace carries the Sentry trace of the publisher, see TraceMiddleware.
+const AttrSentryTrace = sentry.SentryTraceHeader
+
+// TraceMiddleware records publish calls as Sentry spans of the current transaction,
+// and propagates the trace to consumers in message attributes. See NewTraceHandler for the receiving side.
+//
+// Messages are published as is if there's no transaction in the context.
+func TraceMiddleware(topic string) PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, msgs ...*Message) Results {
+			if sentry.TransactionFromContext(ctx) == nil {
+				return next(ctx, msgs...)
+			}
+			span := sentry.StartSpan(ctx, "pubsub.publish")
+			span.Description = topic
+			defer span.Finish()
+			trace := span.ToSentryTrace()
+			list := make([]*Message, 0, len(msgs))
+			for _, m := range msgs {
+				out := *m
+				out.Attrs = make(map[string]string, len(m.Attrs)+1)
+				for k, v := range m.Attrs {
+					out.Attrs[k] = v
+				}
+				out.Attrs[AttrSentryTrace] = trace
+				list = append(list, &out)
+			}
+			res := next(span.Context(), list...)
+			if res.Err() != nil {
+				span.Status = sentry.SpanStatusInternalError
+			} else {
+				span.Status = sentry.SpanStatusOK
+			}
+			return res
+		}
+	}
+}
+
+// NewTraceHandler wraps the handler to run it in a Sentry transaction that continues the trace
+// propagated by TraceMiddleware, if any.
+func NewTraceHandler(name string, h Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		tx := sentry.StartTransaction(ctx, name,
+			sentry.OpName("pubsub.process"),
+			sentry.ContinueFromTrace(msg.Attrs[AttrSentryTrace]),
+		)
+		defer tx.Finish()
+		err := h(tx.Context(), msg)
+		if err != nil {
+			tx.Status = sentry.SpanStatusInternalError
+		} else {
+			tx.Status = sentry.SpanStatusOK
+		}
+		return err
+	}
+}
@@ -0,0 +1,144 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+func TestMiddlewareOrder(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMemPublisher()
+	var calls []string
+	mw := func(name string) PublisherMiddleware {
+		return func(next PublishFunc) PublishFunc {
+			return func(ctx context.Context, msgs ...*Message) Results {
+				calls = append(calls, name)
+				res := next(ctx, msgs...)
+				calls = append(calls, name+" done")
+				return res
+			}
+		}
+	}
+	p := WithMiddleware(mp, mw("a"), mw("b"))
+	require.NoError(t, p.Publish(ctx, []byte("1")))
+	require.Equal(t, []string{"a", "b", "b done", "a done"}, calls)
+	require.Equal(t, []Message{{Data: []byte("1")}}, mp.GetEvents())
+
+	b, err := p.Batch(ctx)
+	require.NoError(t, err)
+	require.NoError(t, b.Publish(ctx, []byte("2")))
+	require.Empty(t, mp.GetEvents())
+	require.NoError(t, b.Flush(ctx))
+	require.Equal(t, []Message{{Data: []byte("2")}}, mp.GetEvents())
+}
+
+func TestAttrsMiddleware(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMemPublisher()
+	p := WithMiddleware(mp, AttrsMiddleware(func(ctx context.Context) map[string]string {
+		return map[string]string{"a": "1", "b": "2"}
+	}))
+	attrs := map[string]string{"b": "3"}
+	require.NoError(t, p.PublishMsg(ctx, &Message{Data: []byte("1"), Attrs: attrs}))
+	require.Equal(t, map[string]string{"b": "3"}, attrs)
+	require.Equal(t, []Message{
+		{Data: []byte("1"), Attrs: map[string]string{"a": "1", "b": "3"}},
+	}, mp.GetEvents())
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMemPublisher()
+	mp.FailAt(errors.New("fail"), 1)
+	p := WithMiddleware(mp, MetricsMiddleware("test-metrics"))
+	okBefore := testutil.ToFloat64(countPublished.WithLabelValues("test-metrics", statusOK))
+	errBefore := testutil.ToFloat64(countPublished.WithLabelValues("test-metrics", statusError))
+	err := p.Publish(ctx, []byte("1"), []byte("2"), []byte("3"))
+	require.Error(t, err)
+	require.Equal(t, 2.0, testutil.ToFloat64(countPublished.WithLabelValues("test-metrics", statusOK))-okBefore)
+	require.Equal(t, 1.0, testutil.ToFloat64(countPublished.WithLabelValues("test-metrics", statusError))-errBefore)
+}
+
+func TestContextMiddleware(t *testing.T) {
+	ctx := context.Background()
+	ctx = report.WithStringValue(ctx, "webhook.event_id", "ev1")
+	ctx = report.WithInt64Value(ctx, "github.install_id", 42)
+	ctx = report.WithStringValues(ctx, "github.node_ids", []string{"a", "b"})
+	ctx = WithMetadata(ctx, Metadata{ID: "m1"})
+
+	mp := NewMemPublisher()
+	p := WithMiddleware(mp, ContextMiddleware())
+	require.NoError(t, p.Publish(ctx, []byte("1")))
+	events := mp.GetEvents()
+	require.Len(t, events, 1)
+	require.Equal(t, map[string]string{
+		AttrContextPrefix + "webhook.event_id":  `"ev1"`,
+		AttrContextPrefix + "github.install_id": `42`,
+		AttrContextPrefix + "github.node_ids":   `["a","b"]`,
+	}, events[0].Attrs)
+
+	var got map[string]interface{}
+	h := NewContextHandler(func(ctx context.Context, msg Message) error {
+		got = report.GetContextMap(ctx)
+		return nil
+	})
+	require.NoError(t, h(context.Background(), events[0]))
+	require.Equal(t, map[string]interface{}{
+		"webhook.event_id":  "ev1",
+		"github.install_id": int64(42),
+		"github.node_ids":   []string{"a", "b"},
+	}, got)
+
+	p = WithMiddleware(mp, ContextMiddleware("webhook.event_id"))
+	require.NoError(t, p.Publish(ctx, []byte("2")))
+	events = mp.GetEvents()
+	require.Equal(t, map[string]string{
+		AttrContextPrefix + "webhook.event_id": `"ev1"`,
+	}, events[0].Attrs)
+
+	// delivery information can't be overwritten by the publisher
+	ctx = WithMetadata(context.Background(), Metadata{ID: "m2"})
+	require.NoError(t, h(ctx, Message{Attrs: map[string]string{
+		AttrContextPrefix + "pubsub.message_id": `"fake"`,
+	}}))
+	require.Equal(t, "m2", got["pubsub.message_id"])
+
+	// types that cannot be restored are ignored
+	require.NoError(t, h(context.Background(), Message{Attrs: map[string]string{
+		AttrContextPrefix + "a": `true`,
+		AttrContextPrefix + "b": `1.5`,
+		AttrContextPrefix + "c": `"c"`,
+	}}))
+	require.Equal(t, map[string]interface{}{"c": "c"}, got)
+}
+
+func TestTraceMiddleware(t *testing.T) {
+	mp := NewMemPublisher()
+	p := WithMiddleware(mp, TraceMiddleware("topic"))
+
+	// no transaction, nothing to propagate
+	require.NoError(t, p.Publish(context.Background(), []byte("1")))
+	require.Nil(t, mp.GetEvents()[0].Attrs)
+
+	tx := sentry.StartTransaction(context.Background(), "test")
+	defer tx.Finish()
+	require.NoError(t, p.Publish(tx.Context(), []byte("2")))
+	events := mp.GetEvents()
+	require.Len(t, events, 1)
+	require.NotEmpty(t, events[0].Attrs[AttrSentryTrace])
+
+	var trace sentry.TraceID
+	h := NewTraceHandler("test", func(ctx context.Context, msg Message) error {
+		trace = sentry.TransactionFromContext(ctx).TraceID
+		return nil
+	})
+	require.NoError(t, h(context.Background(), events[0]))
+	require.Equal(t, tx.TraceID, trace)
+}
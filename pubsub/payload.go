@@ -0,0 +1,334 @@
+package pubsub
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/report"
+)
+
+const (
+	// AttrContentEncoding is set to the compression algorithm of the message data.
+	AttrContentEncoding = "Content-Encoding"
+	// AttrClaimCheck is set to the blob key when the message data was offloaded to a BlobStore.
+	AttrClaimCheck = "com.athenian.claim_check"
+)
+
+const (
+	EncodingGzip = "gzip"
+	EncodingZstd = "zstd"
+)
+
+const (
+	// MaxMessageSize is the max size of a single Pub/Sub message.
+	MaxMessageSize = 10 * 1000 * 1000
+	// DefaultMinCompressSize is the default min size of message data that will be compressed.
+	DefaultMinCompressSize = 1024
+	// DefaultMaxPayloadSize is the default size of the message after which the data is offloaded to a BlobStore.
+	DefaultMaxPayloadSize = 8 * 1024 * 1024 // leave some room for attributes
+	// DefaultMaxDecompressedSize is the default max size of the data after decompression.
+	// It's larger than MaxMessageSize, since payloads offloaded to a BlobStore are not limited by Pub/Sub.
+	DefaultMaxDecompressedSize = 256 * 1024 * 1024
+)
+
+// ErrMessageTooLarge is returned for messages that exceed MaxMessageSize,
+// or if the decompressed data exceeds the limit.
+var ErrMessageTooLarge = errors.New("message is too large")
+
+// ErrInvalidPayload is returned by DecodePayload if the message data cannot be decompressed.
+// It's permanent, thus redelivering the message won't help.
+var ErrInvalidPayload = errors.New("invalid payload")
+
+// BlobStore stores large message payloads.
+//
+// Blobs are never deleted by this package: the storage is expected to expire them,
+// for example, with a bucket lifecycle rule.
+type BlobStore interface {
+	// PutBlob stores the data under the given key.
+	PutBlob(ctx context.Context, key string, data []byte) error
+	// GetBlob loads the data for the key. It returns dbs.ErrNotFound if the blob doesn't exist.
+	GetBlob(ctx context.Context, key string) ([]byte, error)
+}
+
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+	zstdErr  error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		zstdEnc, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDec, zstdErr = newZstdDecoder(DefaultMaxDecompressedSize)
+	})
+	return zstdErr
+}
+
+func newZstdDecoder(limit int) (*zstd.Decoder, error) {
+	window := uint64(limit)
+	if window < zstd.MinWindowSize {
+		window = zstd.MinWindowSize
+	}
+	return zstd.NewReader(nil,
+		zstd.WithDecoderMaxMemory(uint64(limit)),
+		zstd.WithDecoderMaxWindow(window),
+	)
+}
+
+// Compress the data with a given encoding.
+func Compress(data []byte, encoding string) ([]byte, error) {
+	switch encoding {
+	case EncodingGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case EncodingZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEnc.EncodeAll(data, nil), nil
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", encoding)
+	}
+}
+
+// Decompress the data with a given encoding.
+// It returns ErrMessageTooLarge if the result exceeds DefaultMaxDecompressedSize.
+func Decompress(data []byte, encoding string) ([]byte, error) {
+	return DecompressLimit(data, encoding, DefaultMaxDecompressedSize)
+}
+
+// DecompressLimit is similar to Decompress, but returns ErrMessageTooLarge if the result exceeds a given limit.
+func DecompressLimit(data []byte, encoding string, limit int) ([]byte, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("invalid decompression limit: %d", limit)
+	}
+	switch encoding {
+	case EncodingGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+		if err != nil {
+			return nil, err
+		} else if len(out) > limit {
+			return nil, fmt.Errorf("%w: decompressed data exceeds %d bytes", ErrMessageTooLarge, limit)
+		}
+		return out, nil
+	case EncodingZstd:
+		var dec *zstd.Decoder
+		if limit == DefaultMaxDecompressedSize {
+			if err := initZstd(); err != nil {
+				return nil, err
+			}
+			dec = zstdDec
+		} else {
+			var err error
+			if dec, err = newZstdDecoder(limit); err != nil {
+				return nil, err
+			}
+			defer dec.Close()
+		}
+		out, err := dec.DecodeAll(data, nil)
+		if errors.Is(err, zstd.ErrDecoderSizeExceeded) || errors.Is(err, zstd.ErrWindowSizeExceeded) {
+			return nil, fmt.Errorf("%w: decompressed data exceeds %d bytes", ErrMessageTooLarge, limit)
+		}
+		return out, err
+	default:
+		return nil, fmt.Errorf("unsupported content encoding: %q", encoding)
+	}
+}
+
+// PayloadSettings controls compression and offloading of message payloads.
+type PayloadSettings struct {
+	// Encoding is the compression algorithm. Messages are not compressed if it's empty.
+	Encoding string
+	// MinCompressSize is the min size of the data to compress. Defaults to DefaultMinCompressSize.
+	MinCompressSize int
+	// Blobs stores payloads of messages that are larger than MaxSize.
+	// If it's not set, large messages fail with ErrMessageTooLarge.
+	Blobs BlobStore
+	// MaxSize is the max size of the message after compression. Defaults to DefaultMaxPayloadSize.
+	MaxSize int
+}
+
+func (s *PayloadSettings) defaults() {
+	if s.MinCompressSize <= 0 {
+		s.MinCompressSize = DefaultMinCompressSize
+	}
+	if s.MaxSize <= 0 {
+		s.MaxSize = DefaultMaxPayloadSize
+	}
+}
+
+func newBlobKey() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// EncodePayload compresses the message data and offloads it to a blob store, according to the settings.
+// The message is not modified, a new one is returned instead.
+func EncodePayload(ctx context.Context, m *Message, set PayloadSettings) (*Message, error) {
+	set.defaults()
+	out := *m
+	out.Attrs = make(map[string]string, len(m.Attrs)+2)
+	for k, v := range m.Attrs {
+		out.Attrs[k] = v
+	}
+	if _, ok := out.Attrs[AttrContentEncoding]; !ok && set.Encoding != "" && len(out.Data) >= set.MinCompressSize {
+		data, err := Compress(out.Data, set.Encoding)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < len(out.Data) {
+			out.Data = data
+			out.Attrs[AttrContentEncoding] = set.Encoding
+		}
+	}
+	if size := messageSize(&out); size > set.MaxSize {
+		if set.Blobs == nil {
+			return nil, fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, size)
+		}
+		key, err := newBlobKey()
+		if err != nil {
+			return nil, err
+		}
+		if err = set.Blobs.PutBlob(ctx, key, out.Data); err != nil {
+			return nil, fmt.Errorf("cannot offload message payload: %w", err)
+		}
+		out.Data = nil
+		out.Attrs[AttrClaimCheck] = key
+	}
+	if len(out.Attrs) == 0 {
+		out.Attrs = m.Attrs
+	}
+	return &out, nil
+}
+
+// DecodePayload resolves the claim check and decompresses the message data.
+// It returns ErrInvalidPayload if the data cannot be decompressed, for example, if it exceeds the size limit.
+// Attributes set by EncodePayload are removed. The message is not modified, a new one is returned instead.
+//
+// Blob store may be nil, in which case messages with a claim check fail.
+func DecodePayload(ctx context.Context, m *Message, blobs BlobStore) (*Message, error) {
+	key, offloaded := m.Attrs[AttrClaimCheck]
+	enc, compressed := m.Attrs[AttrContentEncoding]
+	if !offloaded && !compressed {
+		return m, nil
+	}
+	out := *m
+	out.Attrs = make(map[string]string, len(m.Attrs))
+	for k, v := range m.Attrs {
+		if k != AttrClaimCheck && k != AttrContentEncoding {
+			out.Attrs[k] = v
+		}
+	}
+	if offloaded {
+		if blobs == nil {
+			return nil, fmt.Errorf("no blob store to resolve the claim check %q", key)
+		}
+		data, err := blobs.GetBlob(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve the claim check %q: %w", key, err)
+		}
+		out.Data = data
+	}
+	if compressed {
+		data, err := Decompress(out.Data, enc)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+		}
+		out.Data = data
+	}
+	return &out, nil
+}
+
+// NewPayloadHandler wraps the handler to resolve claim checks and decompress messages before handling them.
+//
+// Messages with a missing blob or an invalid payload are dropped, since retrying them won't help.
+func NewPayloadHandler(blobs BlobStore, h Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		m, err := DecodePayload(ctx, &msg, blobs)
+		if errors.Is(err, dbs.ErrNotFound) || errors.Is(err, ErrInvalidPayload) {
+			report.Error(ctx, err)
+			return report.NewIgnoredError(err)
+		} else if err != nil {
+			return err
+		}
+		return h(ctx, *m)
+	}
+}
+
+// NewPayloadPublisher wraps the publisher to compress and offload message payloads, according to the settings.
+// Use NewPayloadHandler or DecodePayload on the receiving side.
+func NewPayloadPublisher(p MinPublisher, set PayloadSettings) Publisher {
+	return WithMiddleware(p, PayloadMiddleware(set))
+}
+
+// PayloadMiddleware compresses and offloads message payloads, according to the settings.
+//
+// Messages that failed to encode are not published, as well as the following messages with the same ordering key.
+func PayloadMiddleware(set PayloadSettings) PublisherMiddleware {
+	set.defaults()
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, msgs ...*Message) Results {
+			var (
+				res    = make(Results, len(msgs))
+				list   = make([]*Message, 0, len(msgs))
+				index  = make([]int, 0, len(msgs))
+				paused map[string]struct{}
+			)
+			for i, m := range msgs {
+				if _, ok := paused[m.OrderingKey]; ok {
+					res[i].Err = ErrOrderingKeyPaused
+					continue
+				}
+				em, err := EncodePayload(ctx, m, set)
+				if err != nil {
+					res[i].Err = err
+					if m.OrderingKey != "" {
+						if paused == nil {
+							paused = make(map[string]struct{})
+						}
+						paused[m.OrderingKey] = struct{}{}
+					}
+					continue
+				}
+				list = append(list, em)
+				index = append(index, i)
+			}
+			if len(list) == 0 {
+				return res
+			}
+			for j, r := range next(ctx, list...) {
+				res[index[j]] = r
+			}
+			return res
+		}
+	}
+}
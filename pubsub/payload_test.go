@@ -0,0 +1,155 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/report"
+)
+
+type memBlobs struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func (s *memBlobs) PutBlob(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.blobs == nil {
+		s.blobs = make(map[string][]byte)
+	}
+	s.blobs[key] = append([]byte{}, data...)
+	return nil
+}
+
+func (s *memBlobs) GetBlob(ctx context.Context, key string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, dbs.ErrNotFound
+	}
+	return data, nil
+}
+
+func TestCompress(t *testing.T) {
+	data := bytes.Repeat([]byte("node-id,"), 1000)
+	for _, enc := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(enc, func(t *testing.T) {
+			c, err := Compress(data, enc)
+			require.NoError(t, err)
+			require.Less(t, len(c), len(data))
+			d, err := Decompress(c, enc)
+			require.NoError(t, err)
+			require.Equal(t, data, d)
+		})
+	}
+	_, err := Compress(data, "br")
+	require.Error(t, err)
+}
+
+func TestDecompressLimit(t *testing.T) {
+	data := bytes.Repeat([]byte("a"), 100000)
+	for _, enc := range []string{EncodingGzip, EncodingZstd} {
+		t.Run(enc, func(t *testing.T) {
+			c, err := Compress(data, enc)
+			require.NoError(t, err)
+			_, err = DecompressLimit(c, enc, len(data)-1)
+			require.ErrorIs(t, err, ErrMessageTooLarge)
+			d, err := DecompressLimit(c, enc, len(data))
+			require.NoError(t, err)
+			require.Equal(t, data, d)
+		})
+	}
+}
+
+func TestPayloadPublisher(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMemPublisher()
+	blobs := &memBlobs{}
+	p := NewPayloadPublisher(mp, PayloadSettings{
+		Encoding: EncodingZstd,
+		Blobs:    blobs,
+		MaxSize:  1024,
+	})
+
+	small := []byte("small")
+	medium := bytes.Repeat([]byte("a"), 2000)
+	large := make([]byte, 4096)
+	_, err := rand.Read(large)
+	require.NoError(t, err)
+	attrs := map[string]string{"k": "v"}
+	msgs := []*Message{
+		{Data: small, Attrs: attrs},
+		{Data: medium, Attrs: attrs},
+		{Data: large, Attrs: attrs, OrderingKey: "acc1"},
+	}
+	err = p.PublishMsg(ctx, msgs...)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"k": "v"}, attrs)
+
+	events := mp.GetEvents()
+	require.Len(t, events, 3)
+	require.Equal(t, Message{Data: small, Attrs: attrs}, events[0])
+	require.Equal(t, EncodingZstd, events[1].Attrs[AttrContentEncoding])
+	require.Empty(t, events[1].Attrs[AttrClaimCheck])
+	require.Empty(t, events[2].Data)
+	require.NotEmpty(t, events[2].Attrs[AttrClaimCheck])
+	require.Equal(t, "acc1", events[2].OrderingKey)
+	require.Len(t, blobs.blobs, 1)
+
+	var got []Message
+	h := NewPayloadHandler(blobs, func(ctx context.Context, msg Message) error {
+		got = append(got, msg)
+		return nil
+	})
+	for _, m := range events {
+		require.NoError(t, h(ctx, m))
+	}
+	require.Len(t, got, 3)
+	for i, m := range got {
+		require.Equal(t, msgs[i].Data, m.Data)
+		require.Equal(t, attrs, m.Attrs)
+	}
+	require.Equal(t, "acc1", got[2].OrderingKey)
+}
+
+func TestPayloadTooLarge(t *testing.T) {
+	ctx := context.Background()
+	mp := NewMemPublisher()
+	p := NewPayloadPublisher(mp, PayloadSettings{MaxSize: 10})
+
+	msgs := []*Message{
+		{Data: []byte("0123456789abcdef"), OrderingKey: "k"},
+		{Data: []byte("1"), OrderingKey: "k"},
+		{Data: []byte("2")},
+	}
+	res := PublishWithResults(ctx, p, msgs...)
+	require.ErrorIs(t, res[0].Err, ErrMessageTooLarge)
+	require.ErrorIs(t, res[1].Err, ErrOrderingKeyPaused)
+	require.NoError(t, res[2].Err)
+	require.Equal(t, []Message{{Data: []byte("2")}}, mp.GetEvents())
+}
+
+func TestPayloadHandlerMissingBlob(t *testing.T) {
+	ctx := context.Background()
+	called := false
+	h := NewPayloadHandler(&memBlobs{}, func(ctx context.Context, msg Message) error {
+		called = true
+		return nil
+	})
+	err := h(ctx, Message{Attrs: map[string]string{AttrClaimCheck: "missing"}})
+	require.ErrorIs(t, err, dbs.ErrNotFound)
+	var ierr report.IgnoredError
+	require.ErrorAs(t, err, &ierr)
+	require.False(t, called)
+
+	_, err = DecodePayload(ctx, &Message{Attrs: map[string]string{AttrClaimCheck: "missing"}}, nil)
+	require.Error(t, err)
+}
@@ -0,0 +1,247 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"gopkg.in/yaml.v3"
+
+	"github.com/athenianco/cloud-common/gcp"
+)
+
+// emulatorProject is used as a project ID for the emulator, if it's not set explicitly.
+const emulatorProject = "local"
+
+var createTopics = os.Getenv("ATHENIAN_CREATE_TOPICS") == "true"
+
+// Option configures Pub/Sub publishers and subscribers.
+type Option func(o *clientOptions)
+
+type clientOptions struct {
+	project  string
+	emulator string
+	create   bool
+	topic    string // topic for auto-created subscriptions
+	sub      SubscriptionConfig
+	client   []option.ClientOption
+}
+
+// WithProject sets the GCP project. By default, gcp.ProjectID is used.
+func WithProject(id string) Option {
+	return func(o *clientOptions) {
+		o.project = id
+	}
+}
+
+// WithEmulator connects to the Pub/Sub emulator at a given address.
+// By default, PUBSUB_EMULATOR_HOST is used, if it's set.
+func WithEmulator(host string) Option {
+	return func(o *clientOptions) {
+		o.emulator = host
+	}
+}
+
+// WithAutoCreate enables creating topics and subscriptions if they don't exist.
+// It's enabled by default if ATHENIAN_CREATE_TOPICS is set to true.
+//
+// Subscriptions are only created if the topic is set with WithSubscriptionTopic.
+func WithAutoCreate() Option {
+	return func(o *clientOptions) {
+		o.create = true
+	}
+}
+
+// WithSubscriptionTopic sets the topic and settings for subscriptions created with WithAutoCreate.
+// Concurrency in the config is ignored: it's set separately for the subscriber.
+func WithSubscriptionTopic(topic string, conf SubscriptionConfig) Option {
+	return func(o *clientOptions) {
+		o.topic = topic
+		o.sub = conf
+	}
+}
+
+// WithClientOptions passes additional options to the Pub/Sub client.
+func WithClientOptions(opts ...option.ClientOption) Option {
+	return func(o *clientOptions) {
+		o.client = append(o.client, opts...)
+	}
+}
+
+func newClientOptions(opts []Option) clientOptions {
+	o := clientOptions{
+		emulator: os.Getenv("PUBSUB_EMULATOR_HOST"),
+		create:   createTopics,
+	}
+	for _, fn := range opts {
+		fn(&o)
+	}
+	return o
+}
+
+func newClient(ctx context.Context, o clientOptions) (*gpubsub.Client, error) {
+	project := o.project
+	if project == "" {
+		project = gcp.ProjectID()
+	}
+	copts := o.client
+	if o.emulator != "" {
+		if project == "" {
+			project = emulatorProject
+		}
+		copts = append([]option.ClientOption{
+			option.WithEndpoint(o.emulator),
+			option.WithoutAuthentication(),
+			option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+			option.WithTelemetryDisabled(),
+		}, copts...)
+	}
+	return gpubsub.NewClient(ctx, project, copts...)
+}
+
+// ensureTopic creates the topic if it doesn't exist.
+func ensureTopic(ctx context.Context, client *gpubsub.Client, topicID string) error {
+	exists, err := client.Topic(topicID).Exists(ctx)
+	if err != nil {
+		return err
+	} else if exists {
+		return nil
+	}
+	_, err = client.CreateTopic(ctx, topicID)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// ensureSubscription creates the subscription and its topic if they don't exist.
+func ensureSubscription(ctx context.Context, client *gpubsub.Client, subID, topicID string, conf SubscriptionConfig) error {
+	exists, err := client.Subscription(subID).Exists(ctx)
+	if err != nil {
+		return err
+	} else if exists {
+		return nil
+	}
+	if err = ensureTopic(ctx, client, topicID); err != nil {
+		return err
+	}
+	gconf := gpubsub.SubscriptionConfig{
+		Topic:                 client.Topic(topicID),
+		AckDeadline:           conf.AckDeadline,
+		EnableMessageOrdering: conf.EnableMessageOrdering,
+	}
+	if conf.RetryDelay > 0 {
+		gconf.RetryPolicy = &gpubsub.RetryPolicy{MinimumBackoff: conf.RetryDelay}
+	}
+	if conf.DeadLetterTopic != "" {
+		if conf.MaxDeliveryAttempts <= 0 {
+			return errors.New("max delivery attempts must be set for dead letter topic")
+		}
+		if err = ensureTopic(ctx, client, conf.DeadLetterTopic); err != nil {
+			return err
+		}
+		gconf.DeadLetterPolicy = &gpubsub.DeadLetterPolicy{
+			DeadLetterTopic:     client.Topic(conf.DeadLetterTopic).String(),
+			MaxDeliveryAttempts: conf.MaxDeliveryAttempts,
+		}
+	}
+	_, err = client.CreateSubscription(ctx, subID, gconf)
+	if status.Code(err) == codes.AlreadyExists {
+		return nil
+	}
+	return err
+}
+
+// Manifest declares topics and subscriptions that should exist.
+type Manifest struct {
+	Topics []TopicSpec `yaml:"topics"`
+}
+
+// TopicSpec declares a topic and its subscriptions.
+type TopicSpec struct {
+	Name          string             `yaml:"name"`
+	Subscriptions []SubscriptionSpec `yaml:"subscriptions"`
+}
+
+// SubscriptionSpec declares a subscription.
+type SubscriptionSpec struct {
+	Name               string `yaml:"name"`
+	SubscriptionConfig `yaml:",inline"`
+}
+
+// ParseManifest parses the manifest in YAML or JSON format.
+func ParseManifest(data []byte) (*Manifest, error) {
+	var m Manifest
+	if err := yaml.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("cannot parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// LoadManifest reads the manifest from a YAML or JSON file.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseManifest(data)
+}
+
+// ApplyManifest creates topics and subscriptions declared in the manifest, if they don't exist.
+// Existing topics and subscriptions are not updated.
+func ApplyManifest(ctx context.Context, m *Manifest, opts ...Option) error {
+	client, err := newClient(ctx, newClientOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	for _, t := range m.Topics {
+		if err = ensureTopic(ctx, client, t.Name); err != nil {
+			return fmt.Errorf("cannot create topic %q: %w", t.Name, err)
+		}
+		for _, s := range t.Subscriptions {
+			if err = ensureSubscription(ctx, client, s.Name, t.Name, s.SubscriptionConfig); err != nil {
+				return fmt.Errorf("cannot create subscription %q: %w", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ApplyManifestFromEnv applies the manifest from a file set in PUBSUB_MANIFEST. It does nothing if it's not set.
+func ApplyManifestFromEnv(ctx context.Context, opts ...Option) error {
+	path := os.Getenv("PUBSUB_MANIFEST")
+	if path == "" {
+		return nil
+	}
+	m, err := LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	return ApplyManifest(ctx, m, opts...)
+}
+
+// ApplyManifest creates subscriptions declared in the manifest. Subscriptions are returned by name.
+func (b *MemBroker) ApplyManifest(m *Manifest) (map[string]*MemSubscription, error) {
+	subs := make(map[string]*MemSubscription)
+	for _, t := range m.Topics {
+		b.mu.Lock()
+		b.topic(t.Name)
+		b.mu.Unlock()
+		for _, s := range t.Subscriptions {
+			sub, err := b.Subscribe(t.Name, s.Name, s.SubscriptionConfig)
+			if err != nil {
+				return nil, err
+			}
+			subs[s.Name] = sub
+		}
+	}
+	return subs, nil
+}
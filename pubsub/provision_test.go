@@ -0,0 +1,111 @@
+package pubsub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/pubsub/pstest"
+	"github.com/stretchr/testify/require"
+)
+
+const testManifest = `
+topics:
+  - name: events
+    subscriptions:
+      - name: events-worker
+        ack_deadline: 30s
+        retry_delay: 5s
+        dead_letter_topic: events-dead
+        max_delivery_attempts: 5
+        enable_message_ordering: true
+  - name: other
+`
+
+func TestParseManifest(t *testing.T) {
+	m, err := ParseManifest([]byte(testManifest))
+	require.NoError(t, err)
+	require.Equal(t, &Manifest{Topics: []TopicSpec{
+		{Name: "events", Subscriptions: []SubscriptionSpec{{
+			Name: "events-worker",
+			SubscriptionConfig: SubscriptionConfig{
+				AckDeadline:           30 * time.Second,
+				RetryDelay:            5 * time.Second,
+				DeadLetterTopic:       "events-dead",
+				MaxDeliveryAttempts:   5,
+				EnableMessageOrdering: true,
+			},
+		}}},
+		{Name: "other"},
+	}}, m)
+}
+
+func TestApplyManifest(t *testing.T) {
+	ctx := context.Background()
+	srv := pstest.NewServer()
+	defer srv.Close()
+	opts := []Option{WithEmulator(srv.Addr), WithProject("test")}
+
+	m, err := ParseManifest([]byte(testManifest))
+	require.NoError(t, err)
+	require.NoError(t, ApplyManifest(ctx, m, opts...))
+	// applying again is a no-op
+	require.NoError(t, ApplyManifest(ctx, m, opts...))
+
+	client, err := newClient(ctx, newClientOptions(opts))
+	require.NoError(t, err)
+	defer client.Close()
+	for _, name := range []string{"events", "events-dead", "other"} {
+		ok, err := client.Topic(name).Exists(ctx)
+		require.NoError(t, err)
+		require.True(t, ok, name)
+	}
+	conf, err := client.Subscription("events-worker").Config(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "events", conf.Topic.ID())
+	require.Equal(t, 30*time.Second, conf.AckDeadline)
+	require.True(t, conf.EnableMessageOrdering)
+	require.Equal(t, 5, conf.DeadLetterPolicy.MaxDeliveryAttempts)
+}
+
+func TestAutoCreate(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	srv := pstest.NewServer()
+	defer srv.Close()
+	opts := []Option{WithEmulator(srv.Addr), WithProject("test"), WithAutoCreate()}
+
+	sub, err := NewSubscriber("sub", 1, append(opts, WithSubscriptionTopic("topic", SubscriptionConfig{}))...)
+	require.NoError(t, err)
+	defer sub.Close()
+	p, err := NewPublisher("topic", opts...)
+	require.NoError(t, err)
+	require.NoError(t, p.Publish(ctx, []byte("1")))
+
+	got := make(chan Message, 1)
+	rctx, stop := context.WithCancel(ctx)
+	defer stop()
+	go func() {
+		_ = sub.Receive(rctx, func(ctx context.Context, msg Message) error {
+			got <- msg
+			stop()
+			return nil
+		})
+	}()
+	select {
+	case msg := <-got:
+		require.Equal(t, []byte("1"), msg.Data)
+	case <-ctx.Done():
+		t.Fatal("timeout")
+	}
+}
+
+func TestMemBrokerManifest(t *testing.T) {
+	m, err := ParseManifest([]byte(testManifest))
+	require.NoError(t, err)
+	b := NewMemBroker()
+	subs, err := b.ApplyManifest(m)
+	require.NoError(t, err)
+	require.Len(t, subs, 1)
+	require.Equal(t, "events-worker", subs["events-worker"].Name())
+}
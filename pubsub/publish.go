@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	gpubsub "cloud.google.com/go/pubsub"
 
-	"github.com/athenianco/cloud-common/gcp"
 	"github.com/athenianco/cloud-common/report"
 )
 
@@ -20,11 +20,15 @@ const (
 
 var checkTopics = os.Getenv("ATHENIAN_CHECK_TOPICS") == "true"
 
-var _ Publisher = (*gcpPublisher)(nil)
+var (
+	_ Publisher       = (*gcpPublisher)(nil)
+	_ ResultPublisher = (*gcpPublisher)(nil)
+)
 
 // gcpPublisher is Google Pub/Sub publisher.
 type gcpPublisher struct {
-	topic *gpubsub.Topic
+	client *gpubsub.Client
+	topic  *gpubsub.Topic
 }
 
 // NewPublisherFromEnv is similar to NewPublisher, but takes
@@ -42,108 +46,179 @@ func NewPublisherFromEnv(envs ...string) (Publisher, error) {
 }
 
 // NewPublisher creates a new instance of Pub/Sub publisher.
-// It also creates the Pub/Sub topic if it does not exist.
-func NewPublisher(topicID string) (Publisher, error) {
+// The topic is created if it does not exist, if WithAutoCreate is set.
+func NewPublisher(topicID string, opts ...Option) (Publisher, error) {
 	ctx := context.Background()
 
-	client, err := gpubsub.NewClient(ctx, gcp.ProjectID())
+	o := newClientOptions(opts)
+	client, err := newClient(ctx, o)
 	if err != nil {
 		report.Error(ctx, err)
 		return nil, err
 	}
 
+	if o.create {
+		if err = ensureTopic(ctx, client, topicID); err != nil {
+			report.Error(ctx, err)
+			_ = client.Close()
+			return nil, err
+		}
+	}
 	topic := client.Topic(topicID)
-	if checkTopics {
+	// messages without ordering key are not affected
+	topic.EnableMessageOrdering = true
+	if checkTopics && !o.create {
 		exists, err := topic.Exists(ctx)
 		if err != nil {
 			report.Error(ctx, err)
+			_ = client.Close()
 			return nil, err
 		} else if !exists {
 			err = fmt.Errorf("topic doesn't exist: %q", topicID)
 			report.Error(ctx, err)
+			_ = client.Close()
 			return nil, err
 		}
 	}
-	return &gcpPublisher{topic: topic}, nil
+	return &gcpPublisher{client: client, topic: topic}, nil
 }
 
-// Publish messages to the Pub/Sub topic synchronously.
-func (p *gcpPublisher) Publish(ctx context.Context, msgs ...[]byte) error {
-	res := make([]*gpubsub.PublishResult, 0, len(msgs))
-	for _, data := range msgs {
-		r := p.topic.Publish(ctx, &gpubsub.Message{Data: data})
-		res = append(res, r)
-	}
-	var last error
-	for _, r := range res {
-		_, err := r.Get(ctx)
-		if err != nil {
-			last = err
-			report.Error(ctx, err)
-		}
+// gcpResult is a pending publish result.
+type gcpResult struct {
+	res *gpubsub.PublishResult
+	key string
+	err error // set if the message was rejected before publishing
+}
+
+func (r gcpResult) id(ctx context.Context) (string, error) {
+	if r.err != nil {
+		return "", r.err
 	}
-	return last
+	return r.res.Get(ctx)
 }
 
-// PublishMsg publishes messages to the Pub/Sub topic synchronously.
-func (p *gcpPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
-	res := make([]*gpubsub.PublishResult, 0, len(msgs))
+// gcpPublish starts publishing all messages.
+//
+// Messages larger than MaxMessageSize are rejected without sending them. As with other errors,
+// the following messages with the same ordering key are not published.
+func gcpPublish(ctx context.Context, topic *gpubsub.Topic, msgs []*Message) []gcpResult {
+	var (
+		res    = make([]gcpResult, 0, len(msgs))
+		paused map[string]struct{}
+	)
 	for _, m := range msgs {
-		r := p.topic.Publish(ctx, &gpubsub.Message{Data: m.Data, Attributes: m.Attrs})
-		res = append(res, r)
+		if _, ok := paused[m.OrderingKey]; ok {
+			res = append(res, gcpResult{key: m.OrderingKey, err: ErrOrderingKeyPaused})
+			continue
+		}
+		if size := messageSize(m); size > MaxMessageSize {
+			res = append(res, gcpResult{key: m.OrderingKey, err: fmt.Errorf("%w: %d bytes", ErrMessageTooLarge, size)})
+			if m.OrderingKey != "" {
+				if paused == nil {
+					paused = make(map[string]struct{})
+				}
+				paused[m.OrderingKey] = struct{}{}
+			}
+			continue
+		}
+		r := topic.Publish(ctx, &gpubsub.Message{Data: m.Data, Attributes: m.Attrs, OrderingKey: m.OrderingKey})
+		res = append(res, gcpResult{res: r, key: m.OrderingKey})
 	}
-	var last error
-	for _, r := range res {
-		_, err := r.Get(ctx)
+	return res
+}
+
+// gcpWait waits for all publish results.
+//
+// If publishing a message with an ordering key fails, Pub/Sub pauses publishing for that key.
+// All following messages with the same key fail as well. After all results are collected,
+// publishing is resumed for failed keys, so the caller can retry them in the same order.
+func gcpWait(ctx context.Context, topic *gpubsub.Topic, res []gcpResult) Results {
+	var (
+		out    = make(Results, len(res))
+		failed map[string]struct{}
+	)
+	for i, r := range res {
+		id, err := r.id(ctx)
+		out[i] = PublishResult{ID: id, Err: err}
 		if err != nil {
-			last = err
+			if r.key != "" {
+				if _, ok := failed[r.key]; ok {
+					// the first error for this key was already reported
+					continue
+				}
+			}
 			report.Error(ctx, err)
+			if r.key != "" {
+				if failed == nil {
+					failed = make(map[string]struct{})
+				}
+				failed[r.key] = struct{}{}
+			}
 		}
 	}
-	return last
+	for key := range failed {
+		topic.ResumePublish(key)
+	}
+	return out
 }
 
-func (p *gcpPublisher) Batch(ctx context.Context) (Batch, error) {
-	return &gcpBatch{topic: p.topic}, nil
+// Publish messages to the Pub/Sub topic synchronously.
+func (p *gcpPublisher) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return gcpWait(ctx, p.topic, gcpPublish(ctx, p.topic, list)).Err()
 }
 
-type gcpBatch struct {
-	topic *gpubsub.Topic
-	res   []*gpubsub.PublishResult
+// PublishMsg publishes messages to the Pub/Sub topic synchronously.
+func (p *gcpPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return gcpWait(ctx, p.topic, gcpPublish(ctx, p.topic, msgs)).Err()
 }
 
-func (b *gcpBatch) Publish(ctx context.Context, msgs ...[]byte) error {
-	for _, data := range msgs {
-		r := b.topic.Publish(ctx, &gpubsub.Message{Data: data})
-		b.res = append(b.res, r)
-	}
-	return nil
+// PublishResults publishes messages to the Pub/Sub topic synchronously and returns per-message results.
+func (p *gcpPublisher) PublishResults(ctx context.Context, msgs ...*Message) Results {
+	return gcpWait(ctx, p.topic, gcpPublish(ctx, p.topic, msgs))
 }
 
-func (b *gcpBatch) PublishMsg(ctx context.Context, msgs ...*Message) error {
-	for _, m := range msgs {
-		r := b.topic.Publish(ctx, &gpubsub.Message{Data: m.Data, Attributes: m.Attrs})
-		b.res = append(b.res, r)
+// Batch creates a batch that buffers messages and publishes them when one of the limits is reached,
+// or when it's flushed explicitly.
+func (p *gcpPublisher) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
+	return NewBatch(p, opts...), nil
+}
+
+// Close waits for pending messages and releases the client.
+func (p *gcpPublisher) Close() error {
+	p.topic.Stop()
+	return p.client.Close()
+}
+
+// Check that the topic exists and is accessible.
+func (p *gcpPublisher) Check(ctx context.Context) error {
+	exists, err := p.topic.Exists(ctx)
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("topic doesn't exist: %q", p.topic.ID())
 	}
 	return nil
 }
 
-func (b *gcpBatch) Flush(ctx context.Context) error {
-	var last error
-	for _, r := range b.res {
-		_, err := r.Get(ctx)
-		if err != nil {
-			last = err
-			report.Error(ctx, err)
-		}
+// CheckPublisher checks that the publisher can publish messages, if it supports the check.
+// It's a no-op for other publishers.
+func CheckPublisher(ctx context.Context, p MinPublisher) error {
+	if c, ok := p.(interface {
+		Check(ctx context.Context) error
+	}); ok {
+		return c.Check(ctx)
 	}
-	b.res = nil
-	return last
+	return nil
 }
 
-func (b *gcpBatch) Close() error {
-	if len(b.res) != 0 {
-		report.Message(context.Background(), "dropped %d events", len(b.res))
+// ClosePublisher closes the publisher, if it holds any resources. It's a no-op for other publishers.
+func ClosePublisher(p MinPublisher) error {
+	if c, ok := p.(io.Closer); ok {
+		return c.Close()
 	}
 	return nil
 }
@@ -155,6 +230,12 @@ func PublishJSON(ctx context.Context, p MinPublisher, vals ...interface{}) error
 
 // PublishJSONWith publishes values as JSON to Pub/Sub topic synchronously and attaches atributes to it.
 func PublishJSONWith(ctx context.Context, p MinPublisher, attrs map[string]string, vals ...interface{}) error {
+	return PublishJSONOrdered(ctx, p, "", attrs, vals...)
+}
+
+// PublishJSONOrdered is similar to PublishJSONWith, but also sets an ordering key for all messages.
+// Messages with the same ordering key are delivered in the order they were published.
+func PublishJSONOrdered(ctx context.Context, p MinPublisher, key string, attrs map[string]string, vals ...interface{}) error {
 	if _, ok := attrs[AttrContentType]; !ok {
 		if attrs == nil {
 			attrs = make(map[string]string)
@@ -167,7 +248,7 @@ func PublishJSONWith(ctx context.Context, p MinPublisher, attrs map[string]strin
 		if err != nil {
 			return fmt.Errorf("failed to encode the value: %v", err)
 		}
-		msgs = append(msgs, &Message{Data: data, Attrs: attrs})
+		msgs = append(msgs, &Message{Data: data, Attrs: attrs, OrderingKey: key})
 	}
 	return p.PublishMsg(ctx, msgs...)
 }
@@ -3,6 +3,7 @@ package pubsub
 import (
 	"context"
 	"encoding/json"
+	"strconv"
 	"sync"
 )
 
@@ -16,7 +17,7 @@ type Publisher interface {
 	// Publish messages to the Pub/Sub topic synchronously.
 	Publish(ctx context.Context, msgs ...[]byte) error
 	// Batch starts a batch publish operation.
-	Batch(ctx context.Context) (Batch, error)
+	Batch(ctx context.Context, opts ...BatchOption) (Batch, error)
 }
 
 type Batch interface {
@@ -24,9 +25,13 @@ type Batch interface {
 	Publish(ctx context.Context, msgs ...[]byte) error
 	// PublishMsg publishes messages to the Pub/Sub topic asynchronously.
 	PublishMsg(ctx context.Context, msgs ...*Message) error
-	// Flush all buffered messages.
+	// Flush all buffered messages. It returns errors for all messages that failed to publish
+	// since the last Flush, joined together.
 	Flush(ctx context.Context) error
-	// Close the batch without publishing buffered messages.
+	// FlushResults flushes all buffered messages and returns results for all messages published since
+	// the last flush, in the order they were added to the batch. The error is only returned if ctx is cancelled.
+	FlushResults(ctx context.Context) (Results, error)
+	// Close the batch. Buffered messages are either published or dropped, depending on the CloseMode.
 	Close() error
 }
 
@@ -35,12 +40,45 @@ func NewMemPublisher() *MemPublisher {
 	return &MemPublisher{}
 }
 
-var _ Publisher = (*MemPublisher)(nil)
+var (
+	_ Publisher       = (*MemPublisher)(nil)
+	_ ResultPublisher = (*MemPublisher)(nil)
+)
 
 // MemPublisher stores events to memory.
+//
+// Events are stored in the order they were published, thus the order of messages
+// with the same ordering key is preserved as well.
 type MemPublisher struct {
 	mu     sync.Mutex
 	events []Message
+	seq    int // number of messages passed to the publisher
+	fails  map[int]error
+}
+
+// FailAt makes the publisher fail messages with given sequence numbers with an error.
+// Sequence number is the index of the message among all messages passed to this publisher, starting from 0,
+// including the failed ones.
+//
+// As in Pub/Sub, the following messages with the same ordering key in the same call fail with ErrOrderingKeyPaused.
+func (p *MemPublisher) FailAt(err error, seq ...int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fails == nil {
+		p.fails = make(map[int]error)
+	}
+	for _, i := range seq {
+		p.fails[i] = err
+	}
+}
+
+// GetEventsByKey gets all received events grouped by the ordering key and clears the list.
+func (p *MemPublisher) GetEventsByKey() map[string][]Message {
+	out := make(map[string][]Message)
+	for _, m := range p.GetEvents() {
+		out[m.OrderingKey] = append(out[m.OrderingKey], m)
+	}
+	return out
 }
 
 // GetEvents gets all received events and clears the list.
@@ -52,62 +90,116 @@ func (p *MemPublisher) GetEvents() []Message {
 	return events
 }
 
-func (p *MemPublisher) publish(m *Message) error {
+// copyMessage makes a deep copy of the message by passing it through JSON.
+func copyMessage(m *Message) (Message, error) {
 	data, err := json.Marshal(m)
 	if err != nil {
-		return err
+		return Message{}, err
 	}
 	var msg Message
 	if err = json.Unmarshal(data, &msg); err != nil {
-		return err
+		return Message{}, err
+	}
+	return msg, nil
+}
+
+func (p *MemPublisher) publish(m *Message) (string, error) {
+	seq := p.seq
+	p.seq++
+	if err := p.fails[seq]; err != nil {
+		delete(p.fails, seq)
+		return "", err
+	}
+	msg, err := copyMessage(m)
+	if err != nil {
+		return "", err
 	}
 	p.events = append(p.events, msg)
-	return nil
+	return strconv.Itoa(seq), nil
 }
 
 // Publish saves events to memory.
 func (p *MemPublisher) Publish(ctx context.Context, msgs ...[]byte) error {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	for _, m := range msgs {
-		if err := p.publish(&Message{Data: m}); err != nil {
-			return err
-		}
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
 	}
-	return nil
+	return p.PublishResults(ctx, list...).Err()
 }
 
 // PublishMsg saves events to memory.
 func (p *MemPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return p.PublishResults(ctx, msgs...).Err()
+}
+
+// PublishResults saves events to memory and returns per-message results.
+// Message ID is set to the sequence number of the message (see FailAt).
+func (p *MemPublisher) PublishResults(ctx context.Context, msgs ...*Message) Results {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	for _, m := range msgs {
-		if err := p.publish(m); err != nil {
-			return err
+	var (
+		res    = make(Results, len(msgs))
+		paused map[string]struct{}
+	)
+	for i, m := range msgs {
+		if _, ok := paused[m.OrderingKey]; ok {
+			p.seq++
+			res[i].Err = ErrOrderingKeyPaused
+			continue
+		}
+		res[i].ID, res[i].Err = p.publish(m)
+		if res[i].Err != nil && m.OrderingKey != "" {
+			if paused == nil {
+				paused = make(map[string]struct{})
+			}
+			paused[m.OrderingKey] = struct{}{}
 		}
 	}
-	return nil
+	return res
 }
 
-// Batch creates a batch that saves events to memory.
-func (p *MemPublisher) Batch(ctx context.Context) (Batch, error) {
+// Batch creates a batch that saves events to memory. Events are saved immediately, options are ignored.
+func (p *MemPublisher) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
 	return &memBatch{p: p}, nil
 }
 
 type memBatch struct {
-	p *MemPublisher
+	p Publisher
+
+	mu      sync.Mutex
+	results Results
 }
 
 func (b *memBatch) Publish(ctx context.Context, msgs ...[]byte) error {
-	return b.p.Publish(ctx, msgs...)
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return b.PublishMsg(ctx, list...)
 }
 
+// PublishMsg publishes messages synchronously. Failures are returned by the next Flush call.
 func (b *memBatch) PublishMsg(ctx context.Context, msgs ...*Message) error {
-	return b.p.PublishMsg(ctx, msgs...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.results = append(b.results, PublishWithResults(ctx, b.p, msgs...)...)
+	return nil
 }
 
 func (b *memBatch) Flush(ctx context.Context) error {
-	return nil
+	res, err := b.FlushResults(ctx)
+	if err != nil {
+		return err
+	}
+	return res.Err()
+}
+
+func (b *memBatch) FlushResults(ctx context.Context) (Results, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	res := b.results
+	b.results = nil
+	return res, nil
 }
 
 func (b *memBatch) Close() error {
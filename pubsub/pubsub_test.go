@@ -0,0 +1,118 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// jitterPublisher delays each publish call by a random time.
+type jitterPublisher struct {
+	p *MemPublisher
+}
+
+func (p *jitterPublisher) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	return p.p.PublishMsg(ctx, msgs...)
+}
+
+// testOrdering publishes messages with the same ordering keys from several goroutines per key,
+// and checks that messages of each goroutine are received in the order they were published.
+func testOrdering(t *testing.T, pub MinPublisher, p *MemPublisher, flush func() error) {
+	ctx := context.Background()
+	const (
+		keys    = 4
+		writers = 3
+		n       = 50
+	)
+	var wg sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		key := "acc" + strconv.Itoa(k)
+		for w := 0; w < writers; w++ {
+			w := w
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < n; i++ {
+					err := PublishJSONOrdered(ctx, pub, key, nil, [2]int{w, i})
+					assert.NoError(t, err)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	require.NoError(t, flush())
+
+	byKey := p.GetEventsByKey()
+	require.Len(t, byKey, keys)
+	for key, msgs := range byKey {
+		require.Len(t, msgs, writers*n, key)
+		next := make([]int, writers)
+		for _, m := range msgs {
+			require.Equal(t, key, m.OrderingKey)
+			var v [2]int
+			require.NoError(t, json.Unmarshal(m.Data, &v))
+			w, i := v[0], v[1]
+			require.Equal(t, next[w], i, "%s: writer %d", key, w)
+			next[w]++
+		}
+	}
+	require.Empty(t, p.GetEvents())
+}
+
+func TestMemPublisherOrdering(t *testing.T) {
+	p := NewMemPublisher()
+	testOrdering(t, p, p, func() error { return nil })
+}
+
+func TestBatchOrdering(t *testing.T) {
+	p := NewMemPublisher()
+	b := NewBatch(&jitterPublisher{p: p}, WithMaxPending(7), WithMaxLatency(time.Millisecond))
+	testOrdering(t, b, p, func() error { return b.Flush(context.Background()) })
+	require.NoError(t, b.Close())
+}
+
+func TestBatchOrderingFailure(t *testing.T) {
+	ctx := context.Background()
+	p := NewMemPublisher()
+	errFail := errors.New("fail")
+	p.FailAt(errFail, 0)
+
+	// each message is published in a separate call
+	b := NewBatch(p, WithMaxPending(1), WithMaxLatency(0))
+	require.NoError(t, b.PublishMsg(ctx,
+		&Message{Data: []byte("k1"), OrderingKey: "k"},
+		&Message{Data: []byte("x1"), OrderingKey: "x"},
+		&Message{Data: []byte("k2"), OrderingKey: "k"},
+	))
+	res, err := b.FlushResults(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Results{
+		{Err: errFail},
+		{ID: "1"},
+		{Err: ErrOrderingKeyPaused},
+	}, res)
+	require.Equal(t, []string{"x1"}, eventData(p.GetEvents()))
+
+	// the key is resumed after the failure is returned by the flush
+	require.NoError(t, b.PublishMsg(ctx, &Message{Data: []byte("k3"), OrderingKey: "k"}))
+	require.NoError(t, b.Flush(ctx))
+	require.Equal(t, []string{"k3"}, eventData(p.GetEvents()))
+	require.NoError(t, b.Close())
+}
+
+func eventData(msgs []Message) []string {
+	var out []string
+	for _, m := range msgs {
+		out = append(out, string(m.Data))
+	}
+	return out
+}
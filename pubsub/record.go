@@ -0,0 +1,196 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+// Record is a single recorded message. It's encoded as a JSON object with Message fields and the time.
+type Record struct {
+	Message
+	// Time is the time when the message was published or received.
+	Time time.Time `json:"time"`
+}
+
+// NewRecorder creates a recorder that writes messages to w as JSON lines.
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{enc: json.NewEncoder(w), now: time.Now}
+}
+
+// Recorder writes messages as JSON lines. It can be replayed with Replay.
+type Recorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	now func() time.Time
+}
+
+// Record writes the message with a given time. Current time is used if it's zero.
+func (r *Recorder) Record(m *Message, t time.Time) error {
+	if t.IsZero() {
+		t = r.now()
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.enc.Encode(Record{Message: *m, Time: t.UTC()})
+}
+
+// Middleware records messages that were published successfully.
+// Errors are reported, but do not affect publishing.
+func (r *Recorder) Middleware() PublisherMiddleware {
+	return func(next PublishFunc) PublishFunc {
+		return func(ctx context.Context, msgs ...*Message) Results {
+			res := next(ctx, msgs...)
+			for i, m := range msgs {
+				if res[i].Err != nil {
+					continue
+				}
+				if err := r.Record(m, time.Time{}); err != nil {
+					report.Error(ctx, fmt.Errorf("cannot record the message: %w", err))
+				}
+			}
+			return res
+		}
+	}
+}
+
+// Publisher wraps the publisher to record published messages.
+func (r *Recorder) Publisher(p MinPublisher) Publisher {
+	return WithMiddleware(p, r.Middleware())
+}
+
+// Handler wraps the handler to record received messages before handling them.
+// Publish time from Metadata is used, if it's available.
+func (r *Recorder) Handler(h Handler) Handler {
+	return func(ctx context.Context, msg Message) error {
+		var t time.Time
+		if md, ok := GetMetadata(ctx); ok {
+			t = md.PublishTime
+		}
+		if err := r.Record(&msg, t); err != nil {
+			report.Error(ctx, fmt.Errorf("cannot record the message: %w", err))
+		}
+		return h(ctx, msg)
+	}
+}
+
+// ReplaySettings controls message replay.
+type ReplaySettings struct {
+	// Speed of the replay relative to the recorded time. For example, 1 replays messages in real time,
+	// and 2 replays them twice as fast. If it's zero, messages are replayed without delays.
+	Speed float64
+	// Match is a set of attributes the message must have to be replayed, see Route.
+	Match map[string]string
+}
+
+// ReplaySummary contains replay statistics.
+type ReplaySummary struct {
+	// Total is the number of recorded messages.
+	Total int
+	// Skipped is the number of messages that didn't match the filter.
+	Skipped int
+	// Acked is the number of messages handled successfully or dropped by the handler.
+	Acked int
+	// Failed is the number of messages the handler returned an error for.
+	Failed int
+	// Errors counts handler errors by the error text.
+	Errors map[string]int
+}
+
+// String formats the summary with errors sorted by the count.
+func (s *ReplaySummary) String() string {
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "total: %d, skipped: %d, acked: %d, failed: %d", s.Total, s.Skipped, s.Acked, s.Failed)
+	errs := make([]string, 0, len(s.Errors))
+	for e := range s.Errors {
+		errs = append(errs, e)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		if a, b := s.Errors[errs[i]], s.Errors[errs[j]]; a != b {
+			return a > b
+		}
+		return errs[i] < errs[j]
+	})
+	for _, e := range errs {
+		fmt.Fprintf(&buf, "\n%d\t%s", s.Errors[e], e)
+	}
+	return buf.String()
+}
+
+// Replay reads recorded messages and passes them to the handler one by one.
+// Use funcs.MessageHandler to replay messages against a PubSubHandler.
+//
+// Handler errors are collected in the summary, only read errors and context cancellation stop the replay.
+func Replay(ctx context.Context, r io.Reader, h Handler, set ReplaySettings) (*ReplaySummary, error) {
+	var (
+		sum    = &ReplaySummary{Errors: make(map[string]int)}
+		filter = Route{Match: set.Match}
+		dec    = json.NewDecoder(r)
+		first  time.Time
+		start  = time.Now()
+	)
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); errors.Is(err, io.EOF) {
+			return sum, nil
+		} else if err != nil {
+			return sum, fmt.Errorf("cannot read record %d: %w", sum.Total+1, err)
+		}
+		sum.Total++
+		if !filter.Matches(rec.Attrs) {
+			sum.Skipped++
+			continue
+		}
+		if first.IsZero() {
+			first = rec.Time
+		}
+		if set.Speed > 0 && !rec.Time.IsZero() {
+			at := start.Add(time.Duration(float64(rec.Time.Sub(first)) / set.Speed))
+			if d := time.Until(at); d > 0 {
+				t := time.NewTimer(d)
+				select {
+				case <-ctx.Done():
+					t.Stop()
+					return sum, ctx.Err()
+				case <-t.C:
+				}
+			}
+		} else if err := ctx.Err(); err != nil {
+			return sum, err
+		}
+		mctx := WithMetadata(ctx, Metadata{
+			Subscription:    "replay",
+			ID:              fmt.Sprint(sum.Total),
+			PublishTime:     rec.Time,
+			DeliveryAttempt: 1,
+			OrderingKey:     rec.OrderingKey,
+		})
+		err := h(mctx, rec.Message)
+		var ierr report.IgnoredError
+		if err == nil || (errors.As(err, &ierr) && ierr.Ignored()) {
+			sum.Acked++
+			continue
+		}
+		sum.Failed++
+		sum.Errors[err.Error()]++
+	}
+}
+
+// ReplayFile is similar to Replay, but reads messages from a file.
+func ReplayFile(ctx context.Context, path string, h Handler, set ReplaySettings) (*ReplaySummary, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Replay(ctx, f, h, set)
+}
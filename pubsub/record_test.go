@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+func TestRecorder(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := base
+	rec.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+
+	mp := NewMemPublisher()
+	mp.FailAt(errors.New("fail"), 1)
+	p := rec.Publisher(mp)
+	err := p.PublishMsg(ctx,
+		&Message{Data: []byte("0"), Attrs: map[string]string{"type": "a"}},
+		&Message{Data: []byte("1")},
+		&Message{Data: []byte("2"), OrderingKey: "k"},
+	)
+	require.Error(t, err)
+
+	h := rec.Handler(func(ctx context.Context, msg Message) error { return nil })
+	mctx := WithMetadata(ctx, Metadata{PublishTime: base.Add(time.Minute)})
+	require.NoError(t, h(mctx, Message{Data: []byte("3")}))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Equal(t, []string{
+		`{"data":"MA==","attributes":{"type":"a"},"time":"2023-01-01T00:00:01Z"}`,
+		`{"data":"Mg==","orderingKey":"k","time":"2023-01-01T00:00:02Z"}`,
+		`{"data":"Mw==","time":"2023-01-01T00:01:00Z"}`,
+	}, lines)
+}
+
+func TestReplay(t *testing.T) {
+	ctx := context.Background()
+	const records = `
+{"data":"MA==","attributes":{"type":"a"},"time":"2023-01-01T00:00:00Z"}
+{"data":"MQ==","attributes":{"type":"b"},"time":"2023-01-01T00:00:00.1Z"}
+{"data":"Mg==","attributes":{"type":"a"},"orderingKey":"k","time":"2023-01-01T00:00:00.2Z"}
+{"data":"Mw==","attributes":{"type":"a"},"time":"2023-01-01T00:00:00.3Z"}
+{"data":"NA==","attributes":{"type":"a"},"time":"2023-01-01T00:00:00.4Z"}
+`
+	var (
+		got []string
+		mds []Metadata
+	)
+	h := func(ctx context.Context, msg Message) error {
+		got = append(got, string(msg.Data))
+		md, _ := GetMetadata(ctx)
+		mds = append(mds, md)
+		switch string(msg.Data) {
+		case "2", "3":
+			return errors.New("fail")
+		case "4":
+			return report.NewIgnoredError(errors.New("drop"))
+		}
+		return nil
+	}
+	start := time.Now()
+	sum, err := Replay(ctx, strings.NewReader(records), h, ReplaySettings{
+		Speed: 10,
+		Match: map[string]string{"type": "a"},
+	})
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+	require.Equal(t, []string{"0", "2", "3", "4"}, got)
+	require.Equal(t, "k", mds[1].OrderingKey)
+	require.Equal(t, &ReplaySummary{
+		Total:   5,
+		Skipped: 1,
+		Acked:   2,
+		Failed:  2,
+		Errors:  map[string]int{"fail": 2},
+	}, sum)
+	require.Equal(t, "total: 5, skipped: 1, acked: 2, failed: 2\n2\tfail", sum.String())
+
+	_, err = Replay(ctx, strings.NewReader("{bad"), h, ReplaySettings{})
+	require.Error(t, err)
+}
@@ -0,0 +1,92 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrOrderingKeyPaused is returned for messages that were not published because publishing
+// of a previous message with the same ordering key failed.
+var ErrOrderingKeyPaused = errors.New("publishing paused for the ordering key")
+
+// PublishResult is the result of publishing a single message.
+type PublishResult struct {
+	// ID is the message ID assigned by the server. It's empty if the message failed.
+	ID string
+	// Err is set if the message failed to publish.
+	Err error
+}
+
+// PublishError is an error for a single message in a publish call.
+type PublishError struct {
+	// Index of the message in the publish call.
+	Index int
+	Err   error
+}
+
+func (e *PublishError) Error() string {
+	return fmt.Sprintf("message %d: %v", e.Index, e.Err)
+}
+
+func (e *PublishError) Unwrap() error {
+	return e.Err
+}
+
+// Results contains publish results in the same order as published messages.
+type Results []PublishResult
+
+// Err returns errors for all failed messages as PublishError, joined together.
+// It returns nil if all messages were published.
+func (r Results) Err() error {
+	var errs []error
+	for i, res := range r {
+		if res.Err != nil {
+			errs = append(errs, &PublishError{Index: i, Err: res.Err})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Failed returns indexes of messages that failed to publish.
+func (r Results) Failed() []int {
+	var out []int
+	for i, res := range r {
+		if res.Err != nil {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// FailedMessages returns messages that failed to publish, preserving the order.
+// It can be used to retry only the failed subset.
+func (r Results) FailedMessages(msgs []*Message) []*Message {
+	var out []*Message
+	for _, i := range r.Failed() {
+		out = append(out, msgs[i])
+	}
+	return out
+}
+
+// ResultPublisher is implemented by publishers that report results for each message separately.
+type ResultPublisher interface {
+	// PublishResults publishes messages to the Pub/Sub topic synchronously and returns per-message results.
+	PublishResults(ctx context.Context, msgs ...*Message) Results
+}
+
+// PublishWithResults publishes messages and returns per-message results.
+//
+// If the publisher doesn't implement ResultPublisher, an error is assigned to all messages.
+func PublishWithResults(ctx context.Context, p MinPublisher, msgs ...*Message) Results {
+	if rp, ok := p.(ResultPublisher); ok {
+		return rp.PublishResults(ctx, msgs...)
+	}
+	res := make(Results, len(msgs))
+	if err := p.PublishMsg(ctx, msgs...); err != nil {
+		for i := range res {
+			res[i].Err = err
+		}
+	}
+	return res
+}
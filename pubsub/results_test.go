@@ -0,0 +1,62 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemPublisherResults(t *testing.T) {
+	ctx := context.Background()
+	p := NewMemPublisher()
+	errFail := errors.New("fail")
+	p.FailAt(errFail, 1, 3)
+
+	msgs := []*Message{
+		{Data: []byte("0")},
+		{Data: []byte("1")},
+		{Data: []byte("2"), OrderingKey: "k"},
+		{Data: []byte("3"), OrderingKey: "k"},
+		{Data: []byte("4"), OrderingKey: "k"},
+		{Data: []byte("5")},
+	}
+	res := PublishWithResults(ctx, p, msgs...)
+	require.Equal(t, Results{
+		{ID: "0"},
+		{Err: errFail},
+		{ID: "2"},
+		{Err: errFail},
+		{Err: ErrOrderingKeyPaused},
+		{ID: "5"},
+	}, res)
+	require.Equal(t, []int{1, 3, 4}, res.Failed())
+
+	err := res.Err()
+	require.ErrorIs(t, err, errFail)
+	var perr *PublishError
+	require.ErrorAs(t, err, &perr)
+	require.Equal(t, 1, perr.Index)
+
+	// retry only the failed subset
+	failed := res.FailedMessages(msgs)
+	res = PublishWithResults(ctx, p, failed...)
+	require.NoError(t, res.Err())
+	require.Equal(t, Results{{ID: "6"}, {ID: "7"}, {ID: "8"}}, res)
+
+	var data []string
+	for _, m := range p.GetEvents() {
+		data = append(data, string(m.Data))
+	}
+	require.Equal(t, []string{"0", "2", "5", "1", "3", "4"}, data)
+}
+
+func TestPublishWithResultsFallback(t *testing.T) {
+	ctx := context.Background()
+	p := &failPublisher{p: NewMemPublisher(), fail: map[string]bool{"b": true}}
+	res := PublishWithResults(ctx, p, &Message{Data: []byte("a")}, &Message{Data: []byte("b")})
+	require.Len(t, res, 2)
+	require.Equal(t, []int{0, 1}, res.Failed())
+	require.Error(t, res.Err())
+}
@@ -0,0 +1,328 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// AnyValue matches any value of the attribute in Route, as long as the attribute is set.
+const AnyValue = "*"
+
+// ErrNoRoute is returned for messages that don't match any route, if there's no default route.
+var ErrNoRoute = errors.New("no route for the message")
+
+// RouteError is returned for messages that failed to publish to some of the topics.
+//
+// The message was published to all other topics it was routed to, thus publishing it through the router again
+// sends duplicates to those topics. Use Router.PublishTo to retry only the failed topics.
+type RouteError struct {
+	// Failed maps topics that failed to publish the message to the corresponding errors.
+	Failed map[string]error
+}
+
+// Topics returns the failed topics, sorted by name.
+func (e *RouteError) Topics() []string {
+	out := make([]string, 0, len(e.Failed))
+	for t := range e.Failed {
+		out = append(out, t)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func (e *RouteError) Error() string {
+	return e.join().Error()
+}
+
+func (e *RouteError) Unwrap() []error {
+	out := make([]error, 0, len(e.Failed))
+	for _, t := range e.Topics() {
+		out = append(out, e.Failed[t])
+	}
+	return out
+}
+
+func (e *RouteError) join() error {
+	errs := make([]error, 0, len(e.Failed))
+	for _, t := range e.Topics() {
+		errs = append(errs, fmt.Errorf("topic %q: %w", t, e.Failed[t]))
+	}
+	return errors.Join(errs...)
+}
+
+// Route sends messages with matching attributes to a set of topics.
+type Route struct {
+	// Match is a set of attributes the message must have. AnyValue matches any value of the attribute.
+	// Empty set matches all messages.
+	Match map[string]string `json:"match" yaml:"match"`
+	// Topics to send matching messages to.
+	Topics []string `json:"topics" yaml:"topics"`
+}
+
+// Matches checks if message attributes match the route.
+func (r *Route) Matches(attrs map[string]string) bool {
+	for k, exp := range r.Match {
+		v, ok := attrs[k]
+		if !ok || (exp != AnyValue && v != exp) {
+			return false
+		}
+	}
+	return true
+}
+
+// RouterConfig is a set of routing rules for messages.
+//
+// Each message is sent to topics of all matching routes. If no routes match, the message is sent to Default topics.
+type RouterConfig struct {
+	Routes  []Route  `json:"routes" yaml:"routes"`
+	Default []string `json:"default" yaml:"default"`
+}
+
+// ParseRouterConfig parses routing rules in YAML or JSON format.
+func ParseRouterConfig(data []byte) (*RouterConfig, error) {
+	var c RouterConfig
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("cannot parse routes: %w", err)
+	}
+	return &c, nil
+}
+
+// LoadRouterConfig reads routing rules from a YAML or JSON file.
+func LoadRouterConfig(path string) (*RouterConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRouterConfig(data)
+}
+
+// RouterConfigFromEnv reads routing rules from PUBSUB_ROUTES (inline YAML or JSON),
+// or from a file set in PUBSUB_ROUTES_FILE.
+func RouterConfigFromEnv() (*RouterConfig, error) {
+	if s := os.Getenv("PUBSUB_ROUTES"); s != "" {
+		return ParseRouterConfig([]byte(s))
+	}
+	if path := os.Getenv("PUBSUB_ROUTES_FILE"); path != "" {
+		return LoadRouterConfig(path)
+	}
+	return nil, errors.New("PUBSUB_ROUTES or PUBSUB_ROUTES_FILE must be specified")
+}
+
+// Topics returns all topics used in the config, sorted by name.
+func (c *RouterConfig) Topics() []string {
+	seen := make(map[string]struct{})
+	var out []string
+	add := func(topics []string) {
+		for _, t := range topics {
+			if _, ok := seen[t]; !ok {
+				seen[t] = struct{}{}
+				out = append(out, t)
+			}
+		}
+	}
+	for _, r := range c.Routes {
+		add(r.Topics)
+	}
+	add(c.Default)
+	sort.Strings(out)
+	return out
+}
+
+// Route returns topics for a message with given attributes, in the order they appear in the config.
+func (c *RouterConfig) Route(attrs map[string]string) []string {
+	var (
+		out  []string
+		seen map[string]struct{}
+	)
+	for _, r := range c.Routes {
+		if !r.Matches(attrs) {
+			continue
+		}
+		for _, t := range r.Topics {
+			if _, ok := seen[t]; ok {
+				continue
+			}
+			if seen == nil {
+				seen = make(map[string]struct{})
+			}
+			seen[t] = struct{}{}
+			out = append(out, t)
+		}
+	}
+	if len(out) == 0 {
+		return c.Default
+	}
+	return out
+}
+
+var (
+	_ Publisher       = (*Router)(nil)
+	_ ResultPublisher = (*Router)(nil)
+)
+
+// Router is a publisher that sends messages to one or more topics, according to routing rules.
+type Router struct {
+	cfg  RouterConfig
+	pubs map[string]MinPublisher
+}
+
+// NewRouterFromEnv creates a router with rules from RouterConfigFromEnv, and a Pub/Sub publisher for each topic.
+func NewRouterFromEnv() (*Router, error) {
+	cfg, err := RouterConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	pubs := make(map[string]MinPublisher)
+	for _, topic := range cfg.Topics() {
+		p, err := NewPublisher(topic)
+		if err != nil {
+			for _, p := range pubs {
+				_ = ClosePublisher(p)
+			}
+			return nil, err
+		}
+		pubs[topic] = p
+	}
+	return NewRouter(*cfg, pubs)
+}
+
+// NewRouter creates a router with given rules. Publishers must be set for all topics used in the config.
+func NewRouter(cfg RouterConfig, pubs map[string]MinPublisher) (*Router, error) {
+	for _, topic := range cfg.Topics() {
+		if _, ok := pubs[topic]; !ok {
+			return nil, fmt.Errorf("no publisher for topic %q", topic)
+		}
+	}
+	return &Router{cfg: cfg, pubs: pubs}, nil
+}
+
+// Publish messages without attributes synchronously.
+func (r *Router) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return r.PublishResults(ctx, list...).Err()
+}
+
+// PublishMsg publishes messages to topics selected by routing rules synchronously.
+func (r *Router) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return r.PublishResults(ctx, msgs...).Err()
+}
+
+// PublishResults publishes messages to topics selected by routing rules synchronously.
+// Topics are published to concurrently, preserving the order of messages for each topic.
+//
+// The message fails with RouteError if it failed to publish to any of the topics. Otherwise, message ID is set to the ID from the first topic.
+// Since the message may still be published to other topics, retrying it with the router may produce duplicates,
+// see RouteError for details.
+func (r *Router) PublishResults(ctx context.Context, msgs ...*Message) Results {
+	type topicBatch struct {
+		topic string
+		index []int
+		msgs  []*Message
+		res   Results
+	}
+	var (
+		res     = make(Results, len(msgs))
+		batches = make(map[string]*topicBatch)
+		order   []*topicBatch
+		routes  = make([][]string, len(msgs))
+	)
+	for i, m := range msgs {
+		routes[i] = r.cfg.Route(m.Attrs)
+		if len(routes[i]) == 0 {
+			res[i].Err = ErrNoRoute
+			continue
+		}
+		for _, t := range routes[i] {
+			b := batches[t]
+			if b == nil {
+				b = &topicBatch{topic: t}
+				batches[t] = b
+				order = append(order, b)
+			}
+			b.index = append(b.index, i)
+			b.msgs = append(b.msgs, m)
+		}
+	}
+	var wg sync.WaitGroup
+	for _, b := range order {
+		b := b
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b.res = PublishWithResults(ctx, r.pubs[b.topic], b.msgs...)
+		}()
+	}
+	wg.Wait()
+
+	for i, topics := range routes {
+		var failed map[string]error
+		for _, t := range topics {
+			b := batches[t]
+			j := sort.SearchInts(b.index, i)
+			tr := b.res[j]
+			if tr.Err != nil {
+				if failed == nil {
+					failed = make(map[string]error)
+				}
+				failed[t] = tr.Err
+			} else if res[i].ID == "" {
+				res[i].ID = tr.ID
+			}
+		}
+		if failed != nil {
+			res[i] = PublishResult{Err: &RouteError{Failed: failed}}
+		}
+	}
+	return res
+}
+
+// PublishTo publishes messages to a given topic synchronously, ignoring the routing rules.
+// It can be used to retry topics listed in RouteError.
+func (r *Router) PublishTo(ctx context.Context, topic string, msgs ...*Message) Results {
+	p, ok := r.pubs[topic]
+	if !ok {
+		err := fmt.Errorf("no publisher for topic %q", topic)
+		res := make(Results, len(msgs))
+		for i := range res {
+			res[i].Err = err
+		}
+		return res
+	}
+	return PublishWithResults(ctx, p, msgs...)
+}
+
+// Check checks publishers for all topics.
+func (r *Router) Check(ctx context.Context) error {
+	var errs []error
+	for _, topic := range r.cfg.Topics() {
+		if err := CheckPublisher(ctx, r.pubs[topic]); err != nil {
+			errs = append(errs, fmt.Errorf("topic %q: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes publishers for all topics.
+func (r *Router) Close() error {
+	var errs []error
+	for _, topic := range r.cfg.Topics() {
+		if err := ClosePublisher(r.pubs[topic]); err != nil {
+			errs = append(errs, fmt.Errorf("topic %q: %w", topic, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Batch creates a batch that buffers messages and routes them when it's flushed.
+func (r *Router) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
+	return NewBatch(r, opts...), nil
+}
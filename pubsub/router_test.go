@@ -0,0 +1,105 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testRoutes = `
+routes:
+  - match: {com.athenian.github.acc_id: "1"}
+    topics: [acc1, all]
+  - match: {type: "*"}
+    topics: [all, typed]
+default: [default]
+`
+
+func TestRouterConfig(t *testing.T) {
+	cfg, err := ParseRouterConfig([]byte(testRoutes))
+	require.NoError(t, err)
+	require.Equal(t, []string{"acc1", "all", "default", "typed"}, cfg.Topics())
+
+	require.Equal(t, []string{"acc1", "all"}, cfg.Route(map[string]string{"com.athenian.github.acc_id": "1"}))
+	require.Equal(t, []string{"acc1", "all", "typed"}, cfg.Route(map[string]string{"com.athenian.github.acc_id": "1", "type": "push"}))
+	require.Equal(t, []string{"all", "typed"}, cfg.Route(map[string]string{"com.athenian.github.acc_id": "2", "type": "push"}))
+	require.Equal(t, []string{"default"}, cfg.Route(nil))
+
+	js, err := ParseRouterConfig([]byte(`{"routes": [{"match": {"type": "*"}, "topics": ["typed"]}]}`))
+	require.NoError(t, err)
+	require.Equal(t, []string{"typed"}, js.Route(map[string]string{"type": "push"}))
+	require.Empty(t, js.Route(nil))
+
+	path := filepath.Join(t.TempDir(), "routes.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(testRoutes), 0o644))
+	t.Setenv("PUBSUB_ROUTES_FILE", path)
+	fcfg, err := RouterConfigFromEnv()
+	require.NoError(t, err)
+	require.Equal(t, cfg, fcfg)
+}
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	cfg, err := ParseRouterConfig([]byte(testRoutes))
+	require.NoError(t, err)
+
+	pubs := make(map[string]*MemPublisher)
+	mpubs := make(map[string]MinPublisher)
+	for _, topic := range cfg.Topics() {
+		pubs[topic] = NewMemPublisher()
+		mpubs[topic] = pubs[topic]
+	}
+	_, err = NewRouter(*cfg, map[string]MinPublisher{"acc1": pubs["acc1"]})
+	require.Error(t, err)
+	r, err := NewRouter(*cfg, mpubs)
+	require.NoError(t, err)
+
+	errFail := errors.New("fail")
+	pubs["typed"].FailAt(errFail, 0)
+
+	msgs := []*Message{
+		{Data: []byte("0"), Attrs: map[string]string{"com.athenian.github.acc_id": "1"}},
+		{Data: []byte("1"), Attrs: map[string]string{"type": "push"}},
+		{Data: []byte("2")},
+		{Data: []byte("3"), Attrs: map[string]string{"com.athenian.github.acc_id": "1", "type": "push"}},
+	}
+	res := r.PublishResults(ctx, msgs...)
+	require.NoError(t, res[0].Err)
+	require.Equal(t, "0", res[0].ID)
+	require.ErrorIs(t, res[1].Err, errFail)
+	require.Empty(t, res[1].ID)
+	require.NoError(t, res[2].Err)
+	require.NoError(t, res[3].Err)
+	require.Equal(t, "1", res[3].ID)
+
+	data := func(topic string) []string {
+		var out []string
+		for _, m := range pubs[topic].GetEvents() {
+			out = append(out, string(m.Data))
+		}
+		return out
+	}
+	require.Equal(t, []string{"0", "3"}, data("acc1"))
+	require.Equal(t, []string{"0", "1", "3"}, data("all"))
+	require.Equal(t, []string{"3"}, data("typed"))
+	require.Equal(t, []string{"2"}, data("default"))
+
+	// retry only the failed topics
+	var rerr *RouteError
+	require.ErrorAs(t, res[1].Err, &rerr)
+	require.Equal(t, []string{"typed"}, rerr.Topics())
+	for _, topic := range rerr.Topics() {
+		require.NoError(t, r.PublishTo(ctx, topic, msgs[1]).Err())
+	}
+	require.Empty(t, data("all"))
+	require.Equal(t, []string{"1"}, data("typed"))
+	require.Error(t, r.PublishTo(ctx, "unknown", msgs[1]).Err())
+
+	empty, err := NewRouter(RouterConfig{}, nil)
+	require.NoError(t, err)
+	require.ErrorIs(t, empty.Publish(ctx, []byte("1")), ErrNoRoute)
+}
@@ -2,13 +2,335 @@ package pubsub
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	gpubsub "cloud.google.com/go/pubsub"
+
+	"github.com/athenianco/cloud-common/report"
 )
 
+// DefaultConcurrency is the number of messages processed concurrently by a subscriber, if not set explicitly.
+const DefaultConcurrency = 10
+
 // Message is the payload of a Pub/Sub event.
 type Message struct {
 	Data  []byte            `json:"data"`
 	Attrs map[string]string `json:"attributes,omitempty"`
+	// OrderingKey is an optional key for ordered delivery.
+	// Messages with the same key are delivered in the order they were published.
+	OrderingKey string `json:"orderingKey,omitempty"`
 }
 
 // Handler is a Pub/Sub message handler (subscriber).
 type Handler func(ctx context.Context, msg Message) error
+
+type Subscriber interface {
+	// Receive pulls messages from the subscription and calls the handler for each of them.
+	// Handler may be called concurrently. Message is acknowledged if the handler returns no error,
+	// or returns report.IgnoredError. Otherwise, the message is scheduled for redelivery.
+	//
+	// Receive blocks until the context is cancelled or the subscriber is closed.
+	Receive(ctx context.Context, h Handler) error
+	// Close the subscriber.
+	Close() error
+}
+
+// handleMessage calls the handler and decides if the message must be acknowledged.
+func handleMessage(ctx context.Context, h Handler, msg Message) bool {
+	err := h(ctx, msg)
+	if err == nil {
+		return true
+	}
+	var ierr report.IgnoredError
+	if errors.As(err, &ierr) && ierr.Ignored() {
+		report.Debug(ctx, "dropping message: %v", err)
+		return true
+	}
+	report.Error(ctx, err)
+	return false
+}
+
+var _ Subscriber = (*gcpSubscriber)(nil)
+
+// gcpSubscriber is Google Pub/Sub subscriber.
+type gcpSubscriber struct {
+	client *gpubsub.Client
+	sub    *gpubsub.Subscription
+}
+
+// NewSubscriberFromEnv is similar to NewSubscriber, but takes
+// the subscription name from the given environment variable(s).
+func NewSubscriberFromEnv(concurrency int, envs ...string) (Subscriber, error) {
+	for _, env := range envs {
+		if sub := os.Getenv(env); sub != "" {
+			return NewSubscriber(sub, concurrency)
+		}
+	}
+	if len(envs) == 1 {
+		return nil, errors.New(envs[0] + " must be specified")
+	}
+	return nil, fmt.Errorf("one of the %s must be specified", strings.Join(envs, ", "))
+}
+
+// NewSubscriber creates a new instance of Pub/Sub subscriber.
+// Concurrency limits the number of messages processed at the same time.
+// If it's not positive, DefaultConcurrency is used.
+//
+// The subscription is created if it does not exist, if both WithAutoCreate and WithSubscriptionTopic are set.
+func NewSubscriber(subID string, concurrency int, opts ...Option) (Subscriber, error) {
+	ctx := context.Background()
+
+	o := newClientOptions(opts)
+	client, err := newClient(ctx, o)
+	if err != nil {
+		report.Error(ctx, err)
+		return nil, err
+	}
+
+	create := o.create && o.topic != ""
+	if create {
+		if err = ensureSubscription(ctx, client, subID, o.topic, o.sub); err != nil {
+			report.Error(ctx, err)
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	sub := client.Subscription(subID)
+	if checkTopics && !create {
+		exists, err := sub.Exists(ctx)
+		if err != nil {
+			report.Error(ctx, err)
+			_ = client.Close()
+			return nil, err
+		} else if !exists {
+			err = fmt.Errorf("subscription doesn't exist: %q", subID)
+			report.Error(ctx, err)
+			_ = client.Close()
+			return nil, err
+		}
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	sub.ReceiveSettings.MaxOutstandingMessages = concurrency
+	return &gcpSubscriber{client: client, sub: sub}, nil
+}
+
+// Receive messages from the Pub/Sub subscription.
+func (s *gcpSubscriber) Receive(ctx context.Context, h Handler) error {
+	return s.sub.Receive(ctx, func(ctx context.Context, m *gpubsub.Message) {
+		msg := Message{Data: m.Data, Attrs: m.Attributes, OrderingKey: m.OrderingKey}
+		md := Metadata{
+			Subscription: s.sub.String(),
+			ID:           m.ID,
+			PublishTime:  m.PublishTime,
+			OrderingKey:  m.OrderingKey,
+		}
+		if m.DeliveryAttempt != nil {
+			md.DeliveryAttempt = *m.DeliveryAttempt
+		}
+		ctx = WithMetadata(ctx, md)
+		if handleMessage(ctx, h, msg) {
+			m.Ack()
+		} else {
+			m.Nack()
+		}
+	})
+}
+
+func (s *gcpSubscriber) Close() error {
+	return s.client.Close()
+}
+
+// NewMemSubscriber creates a memory-based subscriber implementation that is useful for testing.
+// Messages are sent to it by using the Publisher interface.
+func NewMemSubscriber(concurrency int) *MemSubscriber {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &MemSubscriber{
+		concurrency: concurrency,
+		notify:      make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+}
+
+var (
+	_ Subscriber = (*MemSubscriber)(nil)
+	_ Publisher  = (*MemSubscriber)(nil)
+)
+
+// MemSubscriber delivers messages published to it to the handler.
+//
+// Acknowledged and not acknowledged messages are stored in memory and can be inspected with
+// GetAcked and GetNacked. Messages are not redelivered.
+type MemSubscriber struct {
+	concurrency int
+	notify      chan struct{}
+
+	closeOnce sync.Once
+	closed    chan struct{}
+
+	mu       sync.Mutex
+	queue    []Message
+	inflight int
+	acked    []Message
+	nacked   []Message
+}
+
+// GetAcked gets all acknowledged messages and clears the list.
+func (s *MemSubscriber) GetAcked() []Message {
+	s.mu.Lock()
+	msgs := s.acked
+	s.acked = nil
+	s.mu.Unlock()
+	return msgs
+}
+
+// GetNacked gets all messages that were not acknowledged and clears the list.
+func (s *MemSubscriber) GetNacked() []Message {
+	s.mu.Lock()
+	msgs := s.nacked
+	s.nacked = nil
+	s.mu.Unlock()
+	return msgs
+}
+
+func (s *MemSubscriber) push(msgs ...*Message) error {
+	s.mu.Lock()
+	for _, m := range msgs {
+		msg, err := copyMessage(m)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.queue = append(s.queue, msg)
+	}
+	s.mu.Unlock()
+	s.wakeup()
+	return nil
+}
+
+// wakeup notifies the receiver about the state change.
+func (s *MemSubscriber) wakeup() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// idle checks if there are no queued or in-flight messages.
+func (s *MemSubscriber) idle() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue) == 0 && s.inflight == 0
+}
+
+func (s *MemSubscriber) pop() (Message, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == 0 {
+		return Message{}, false
+	}
+	msg := s.queue[0]
+	s.queue = s.queue[1:]
+	s.inflight++
+	return msg, true
+}
+
+// unpop returns the message that was not delivered back to the queue.
+func (s *MemSubscriber) unpop(msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append([]Message{msg}, s.queue...)
+	s.inflight--
+}
+
+// Publish queues messages for delivery.
+func (s *MemSubscriber) Publish(ctx context.Context, msgs ...[]byte) error {
+	list := make([]*Message, 0, len(msgs))
+	for _, data := range msgs {
+		list = append(list, &Message{Data: data})
+	}
+	return s.push(list...)
+}
+
+// PublishMsg queues messages for delivery.
+func (s *MemSubscriber) PublishMsg(ctx context.Context, msgs ...*Message) error {
+	return s.push(msgs...)
+}
+
+// Batch creates a batch that queues messages for delivery immediately. Options are ignored.
+func (s *MemSubscriber) Batch(ctx context.Context, opts ...BatchOption) (Batch, error) {
+	return &memBatch{p: s}, nil
+}
+
+// Receive delivers queued messages to the handler.
+//
+// It blocks until the context is cancelled or the subscriber is closed.
+// In the latter case, it returns only after all queued messages are delivered,
+// including the ones published by the handler itself.
+func (s *MemSubscriber) Receive(ctx context.Context, h Handler) error {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, s.concurrency)
+	)
+	defer wg.Wait()
+	closing := false
+	for {
+		msg, ok := s.pop()
+		if !ok {
+			if closing && s.idle() {
+				return nil
+			}
+			closed := s.closed
+			if closing {
+				// wait for in-flight messages only
+				closed = nil
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-closed:
+				closing = true
+			case <-s.notify:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			s.unpop(msg)
+			return ctx.Err()
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			ack := handleMessage(ctx, h, msg)
+			s.mu.Lock()
+			if ack {
+				s.acked = append(s.acked, msg)
+			} else {
+				s.nacked = append(s.nacked, msg)
+			}
+			s.inflight--
+			s.mu.Unlock()
+			s.wakeup()
+		}()
+	}
+}
+
+// Close the subscriber. Receive will return after delivering all queued messages.
+func (s *MemSubscriber) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+func TestMemSubscriber(t *testing.T) {
+	ctx := context.Background()
+	sub := NewMemSubscriber(3)
+
+	const n = 20
+	for i := 0; i < n; i++ {
+		err := sub.PublishMsg(ctx, &Message{Data: []byte(strconv.Itoa(i))})
+		require.NoError(t, err)
+	}
+	require.NoError(t, sub.Close())
+
+	var (
+		running int32
+		maxRun  int32
+	)
+	err := sub.Receive(ctx, func(ctx context.Context, msg Message) error {
+		cur := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			prev := atomic.LoadInt32(&maxRun)
+			if cur <= prev || atomic.CompareAndSwapInt32(&maxRun, prev, cur) {
+				break
+			}
+		}
+		i, _ := strconv.Atoi(string(msg.Data))
+		switch i % 3 {
+		case 1:
+			return errors.New("failed")
+		case 2:
+			return report.NewIgnoredError(errors.New("ignored"))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.LessOrEqual(t, maxRun, int32(3))
+
+	ids := func(msgs []Message) []int {
+		var out []int
+		for _, m := range msgs {
+			i, err := strconv.Atoi(string(m.Data))
+			require.NoError(t, err)
+			out = append(out, i)
+		}
+		sort.Ints(out)
+		return out
+	}
+	var (
+		expAcked  []int
+		expNacked []int
+	)
+	for i := 0; i < n; i++ {
+		if i%3 == 1 {
+			expNacked = append(expNacked, i)
+		} else {
+			expAcked = append(expAcked, i)
+		}
+	}
+	require.Equal(t, expAcked, ids(sub.GetAcked()))
+	require.Equal(t, expNacked, ids(sub.GetNacked()))
+}
+
+func TestMemSubscriberChain(t *testing.T) {
+	ctx := context.Background()
+	sub := NewMemSubscriber(0)
+	out := NewMemPublisher()
+
+	require.NoError(t, sub.Publish(ctx, []byte("3")))
+	require.NoError(t, sub.Close())
+
+	// handler publishes back to the same subscriber; Receive must wait for all of them
+	err := sub.Receive(ctx, func(ctx context.Context, msg Message) error {
+		i, err := strconv.Atoi(string(msg.Data))
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			if err = sub.Publish(ctx, []byte(strconv.Itoa(i-1))); err != nil {
+				return err
+			}
+		}
+		return out.PublishMsg(ctx, &msg)
+	})
+	require.NoError(t, err)
+	require.Len(t, out.GetEvents(), 4)
+	require.Len(t, sub.GetAcked(), 4)
+	require.Empty(t, sub.GetNacked())
+}
+
+func TestMemSubscriberCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sub := NewMemSubscriber(1)
+	cancel()
+	err := sub.Receive(ctx, func(ctx context.Context, msg Message) error {
+		return nil
+	})
+	require.Equal(t, context.Canceled, err)
+}
@@ -0,0 +1,202 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/athenianco/cloud-common/report"
+)
+
+const (
+	// AttrSchemaName is the name of the payload schema.
+	AttrSchemaName = "com.athenian.schema.name"
+	// AttrSchemaVersion is the version of the payload schema.
+	AttrSchemaVersion = "com.athenian.schema.version"
+)
+
+// ErrUnsupportedVersion is returned by Decode for messages with a newer schema version than the current one.
+// Such messages are published by a newer version of the producer, for example, during a rolling deploy,
+// thus the error is temporary: they can be handled once the consumer is upgraded.
+var ErrUnsupportedVersion = errors.New("unsupported schema version")
+
+type versionError struct {
+	name          string
+	vers, current int
+}
+
+func (e *versionError) Error() string {
+	return fmt.Sprintf("%v for %q: %d > %d", ErrUnsupportedVersion, e.name, e.vers, e.current)
+}
+
+func (e *versionError) Unwrap() error   { return ErrUnsupportedVersion }
+func (e *versionError) Temporary() bool { return true }
+
+// UpgradeFunc converts a JSON payload from one schema version to the next one.
+type UpgradeFunc func(data json.RawMessage) (json.RawMessage, error)
+
+// Upgrade creates an UpgradeFunc from a typed conversion function.
+func Upgrade[From, To any](fnc func(v *From) (*To, error)) UpgradeFunc {
+	return func(data json.RawMessage) (json.RawMessage, error) {
+		var v From
+		if err := json.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		out, err := fnc(&v)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(out)
+	}
+}
+
+// Schema describes a versioned JSON payload of type T.
+type Schema[T any] struct {
+	name     string
+	version  int
+	upgrades map[int]UpgradeFunc
+}
+
+// NewSchema creates a schema with a given name and the current version.
+// Versions start from 1. Messages without the version attribute are assumed to be of version 1.
+func NewSchema[T any](name string, version int) *Schema[T] {
+	if name == "" {
+		panic("empty schema name")
+	}
+	if version < 1 {
+		panic("schema version must be positive")
+	}
+	return &Schema[T]{name: name, version: version, upgrades: make(map[int]UpgradeFunc)}
+}
+
+// Name returns the schema name.
+func (s *Schema[T]) Name() string {
+	return s.name
+}
+
+// Version returns the current schema version.
+func (s *Schema[T]) Version() int {
+	return s.version
+}
+
+// RegisterUpgrade registers a function that converts the payload of a given version to the next one.
+// All upgrades from the oldest supported version to the current one must be registered.
+func (s *Schema[T]) RegisterUpgrade(from int, fnc UpgradeFunc) *Schema[T] {
+	if from < 1 || from >= s.version {
+		panic(fmt.Errorf("invalid upgrade version for %q: %d", s.name, from))
+	}
+	s.upgrades[from] = fnc
+	return s
+}
+
+// Encode the value to a message, stamping the schema name and version.
+// Attributes are copied.
+func (s *Schema[T]) Encode(v *T, attrs map[string]string) (*Message, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode the value: %v", err)
+	}
+	m := make(map[string]string, len(attrs)+3)
+	for k, v := range attrs {
+		m[k] = v
+	}
+	if _, ok := m[AttrContentType]; !ok {
+		m[AttrContentType] = "application/json"
+	}
+	m[AttrSchemaName] = s.name
+	m[AttrSchemaVersion] = strconv.Itoa(s.version)
+	return &Message{Data: data, Attrs: m}, nil
+}
+
+// Decode the message, upgrading it to the current schema version, if necessary.
+// It returns ErrUnsupportedVersion if the message version is newer than the current one.
+func (s *Schema[T]) Decode(msg *Message) (*T, error) {
+	if name, ok := msg.Attrs[AttrSchemaName]; ok && name != s.name {
+		return nil, fmt.Errorf("unexpected schema: %q, expected %q", name, s.name)
+	}
+	vers := 1
+	if sv, ok := msg.Attrs[AttrSchemaVersion]; ok {
+		v, err := strconv.Atoi(sv)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schema version: %q", sv)
+		}
+		vers = v
+	}
+	if vers > s.version {
+		return nil, &versionError{name: s.name, vers: vers, current: s.version}
+	}
+	data := json.RawMessage(msg.Data)
+	for ; vers < s.version; vers++ {
+		fnc := s.upgrades[vers]
+		if fnc == nil {
+			return nil, fmt.Errorf("no upgrade for %q from version %d", s.name, vers)
+		}
+		var err error
+		data, err = fnc(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot upgrade %q from version %d: %w", s.name, vers, err)
+		}
+	}
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, fmt.Errorf("failed to decode the value: %v", err)
+	}
+	return &v, nil
+}
+
+// TypedPublisher publishes values of type T as JSON messages with a given schema.
+type TypedPublisher[T any] struct {
+	p      MinPublisher
+	schema *Schema[T]
+}
+
+// NewTypedPublisher creates a typed publisher on top of a given publisher.
+func NewTypedPublisher[T any](p MinPublisher, schema *Schema[T]) *TypedPublisher[T] {
+	return &TypedPublisher[T]{p: p, schema: schema}
+}
+
+// Publish values to Pub/Sub topic synchronously.
+func (p *TypedPublisher[T]) Publish(ctx context.Context, vals ...*T) error {
+	return p.PublishOrdered(ctx, "", nil, vals...)
+}
+
+// PublishWith publishes values to Pub/Sub topic synchronously and attaches atributes to them.
+func (p *TypedPublisher[T]) PublishWith(ctx context.Context, attrs map[string]string, vals ...*T) error {
+	return p.PublishOrdered(ctx, "", attrs, vals...)
+}
+
+// PublishOrdered is similar to PublishWith, but also sets an ordering key for all messages.
+func (p *TypedPublisher[T]) PublishOrdered(ctx context.Context, key string, attrs map[string]string, vals ...*T) error {
+	msgs := make([]*Message, 0, len(vals))
+	for _, v := range vals {
+		m, err := p.schema.Encode(v, attrs)
+		if err != nil {
+			return err
+		}
+		m.OrderingKey = key
+		msgs = append(msgs, m)
+	}
+	return p.p.PublishMsg(ctx, msgs...)
+}
+
+// TypedHandler is a Pub/Sub message handler that accepts decoded values.
+type TypedHandler[T any] func(ctx context.Context, v *T, msg Message) error
+
+// NewTypedHandler creates a handler that decodes messages according to the schema.
+//
+// Messages that cannot be decoded are reported and dropped, since redelivering them won't help.
+// Messages with a newer schema version are redelivered instead, see ErrUnsupportedVersion.
+func NewTypedHandler[T any](schema *Schema[T], h TypedHandler[T]) Handler {
+	return func(ctx context.Context, msg Message) error {
+		v, err := schema.Decode(&msg)
+		if errors.Is(err, ErrUnsupportedVersion) {
+			return err
+		} else if err != nil {
+			report.Error(ctx, err)
+			return report.NewIgnoredError(err)
+		}
+		return h(ctx, v, msg)
+	}
+}
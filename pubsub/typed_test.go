@@ -0,0 +1,81 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type eventV1 struct {
+	Name string `json:"name"`
+}
+
+type eventV2 struct {
+	Names []string `json:"names"`
+}
+
+func TestTypedPublisher(t *testing.T) {
+	ctx := context.Background()
+
+	schemaV1 := NewSchema[eventV1]("test.event", 1)
+	schemaV2 := NewSchema[eventV2]("test.event", 2).
+		RegisterUpgrade(1, Upgrade(func(v *eventV1) (*eventV2, error) {
+			return &eventV2{Names: []string{v.Name}}, nil
+		}))
+
+	sub := NewMemSubscriber(1)
+	err := NewTypedPublisher(sub, schemaV1).PublishWith(ctx, map[string]string{"k": "v"}, &eventV1{Name: "a"})
+	require.NoError(t, err)
+	err = NewTypedPublisher(sub, schemaV2).Publish(ctx, &eventV2{Names: []string{"b", "c"}})
+	require.NoError(t, err)
+	// legacy message without schema attributes
+	err = PublishJSON(ctx, sub, eventV1{Name: "d"})
+	require.NoError(t, err)
+	// unsupported version
+	err = NewTypedPublisher(sub, NewSchema[eventV2]("test.event", 3)).Publish(ctx, &eventV2{})
+	require.NoError(t, err)
+	// wrong schema
+	err = NewTypedPublisher(sub, NewSchema[eventV1]("test.other", 1)).Publish(ctx, &eventV1{})
+	require.NoError(t, err)
+	require.NoError(t, sub.Close())
+
+	var got [][]string
+	err = sub.Receive(ctx, NewTypedHandler(schemaV2, func(ctx context.Context, v *eventV2, msg Message) error {
+		got = append(got, v.Names)
+		return nil
+	}))
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"a"}, {"b", "c"}, {"d"}}, got)
+	// decoding errors are dropped, while newer versions are redelivered
+	require.Len(t, sub.GetAcked(), 4)
+	nacked := sub.GetNacked()
+	require.Len(t, nacked, 1)
+	require.Equal(t, "3", nacked[0].Attrs[AttrSchemaVersion])
+}
+
+func TestSchemaEncode(t *testing.T) {
+	schema := NewSchema[eventV1]("test.event", 1)
+	attrs := map[string]string{"k": "v"}
+	msg, err := schema.Encode(&eventV1{Name: "a"}, attrs)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"k": "v"}, attrs)
+	require.Equal(t, map[string]string{
+		"k":               "v",
+		AttrContentType:   "application/json",
+		AttrSchemaName:    "test.event",
+		AttrSchemaVersion: "1",
+	}, msg.Attrs)
+	require.JSONEq(t, `{"name":"a"}`, string(msg.Data))
+
+	_, err = NewSchema[eventV2]("test.event", 3).
+		RegisterUpgrade(2, func(data json.RawMessage) (json.RawMessage, error) { return data, nil }).
+		Decode(msg)
+	require.Error(t, err)
+	require.NotErrorIs(t, err, ErrUnsupportedVersion)
+
+	msg.Attrs[AttrSchemaVersion] = "2"
+	_, err = schema.Decode(msg)
+	require.ErrorIs(t, err, ErrUnsupportedVersion)
+}
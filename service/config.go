@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/report"
+)
+
+// DefaultConfigTTL is the default time the service config is cached for.
+const DefaultConfigTTL = time.Minute
+
+// Config is a JSON configuration of the service, stored in the services table.
+// Top-level keys are settings, for example rate limits, batch sizes or dry-run mode.
+type Config map[string]json.RawMessage
+
+// ParseConfig parses the service config. Empty data is an empty config.
+func ParseConfig(data json.RawMessage) (Config, error) {
+	c := make(Config)
+	if len(data) == 0 {
+		return c, nil
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("invalid service config: %w", err)
+	}
+	return c, nil
+}
+
+// Decode decodes the setting into v. It returns false if the setting is not set.
+func (c Config) Decode(key string, v interface{}) (bool, error) {
+	data, ok := c[key]
+	if !ok || string(data) == "null" {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("invalid service setting %q: %w", key, err)
+	}
+	return true, nil
+}
+
+// ConfigValue returns a typed setting from the config, or def if it is not set or has a wrong type.
+func ConfigValue[T any](c Config, key string, def T) T {
+	var v T
+	if ok, err := c.Decode(key, &v); !ok || err != nil {
+		return def
+	}
+	return v
+}
+
+// NewConfigCache creates a cache for the service config that is refreshed after ttl.
+func NewConfigCache(db Database, name string, ttl time.Duration) *ConfigCache {
+	if ttl <= 0 {
+		ttl = DefaultConfigTTL
+	}
+	return &ConfigCache{db: db, name: name, ttl: ttl}
+}
+
+// ConfigCache caches the service config in-process.
+type ConfigCache struct {
+	db   Database
+	name string
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	conf    Config
+	updated time.Time     // last load, including failed ones
+	loading chan struct{} // closed when the current load finishes
+}
+
+func (c *ConfigCache) cached() Config {
+	if c.conf == nil {
+		return Config{}
+	}
+	return c.conf
+}
+
+// Get returns the service config, loading it if the cached one is older than TTL.
+// The last known config is kept if the load fails, and an empty config is returned if it was never loaded.
+// Failed loads are retried after TTL as well. A service that is not registered has an empty config.
+//
+// Only one call loads the config at a time, concurrent calls return the last known config
+// or wait for the first load to finish.
+func (c *ConfigCache) Get(ctx context.Context) Config {
+	c.mu.Lock()
+	for c.loading != nil && c.conf == nil {
+		ch := c.loading
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return Config{}
+		case <-ch:
+		}
+		c.mu.Lock()
+	}
+	if c.loading != nil || time.Since(c.updated) < c.ttl {
+		conf := c.cached()
+		c.mu.Unlock()
+		return conf
+	}
+	ch := make(chan struct{})
+	c.loading = ch
+	c.mu.Unlock()
+
+	conf, err := c.load(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.loading = nil
+	close(ch)
+	c.updated = time.Now()
+	if err != nil {
+		report.Error(ctx, fmt.Errorf("cannot load service config: %w", err))
+		return c.cached()
+	}
+	c.conf = conf
+	return conf
+}
+
+func (c *ConfigCache) load(ctx context.Context) (Config, error) {
+	data, err := c.db.GetConfig(ctx, c.name)
+	if errors.Is(err, dbs.ErrNotFound) {
+		return Config{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return ParseConfig(data)
+}
+
+// Invalidate forces the config to be loaded on the next Get.
+func (c *ConfigCache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.updated = time.Time{}
+}
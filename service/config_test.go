@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dbs"
+)
+
+// configDatabase is a minimal Database that only serves GetConfig.
+type configDatabase struct {
+	Database
+	conf  json.RawMessage
+	err   error
+	loads int
+}
+
+func (db *configDatabase) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	db.loads++
+	if db.err != nil {
+		return nil, db.err
+	}
+	if db.conf == nil {
+		return nil, dbs.ErrNotFound
+	}
+	return db.conf, nil
+}
+
+func TestConfigValue(t *testing.T) {
+	c, err := ParseConfig(json.RawMessage(`{"batch_size": 50, "dry_run": true, "rate": "fast", "empty": null}`))
+	require.NoError(t, err)
+
+	require.Equal(t, 50, ConfigValue(c, "batch_size", 10))
+	require.Equal(t, true, ConfigValue(c, "dry_run", false))
+	require.Equal(t, "fast", ConfigValue(c, "rate", "slow"))
+	// missing, null or of a wrong type
+	require.Equal(t, 10, ConfigValue(c, "missing", 10))
+	require.Equal(t, 10, ConfigValue(c, "empty", 10))
+	require.Equal(t, 10, ConfigValue(c, "rate", 10))
+
+	var limits struct {
+		PerMinute int `json:"per_minute"`
+	}
+	c, err = ParseConfig(json.RawMessage(`{"limits": {"per_minute": 5}}`))
+	require.NoError(t, err)
+	ok, err := c.Decode("limits", &limits)
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, 5, limits.PerMinute)
+
+	_, err = ParseConfig(json.RawMessage(`[1]`))
+	require.Error(t, err)
+	c, err = ParseConfig(nil)
+	require.NoError(t, err)
+	require.Empty(t, c)
+}
+
+func TestConfigCache(t *testing.T) {
+	ctx := context.Background()
+	db := &configDatabase{}
+	c := NewConfigCache(db, "test-config", time.Hour)
+
+	// not registered
+	require.Equal(t, Config{}, c.Get(ctx))
+
+	db.conf = json.RawMessage(`{"batch_size": 50}`)
+	c.Invalidate()
+	require.Equal(t, 50, ConfigValue(c.Get(ctx), "batch_size", 10))
+	require.Equal(t, 2, db.loads)
+
+	// cached until TTL
+	db.conf = json.RawMessage(`{"batch_size": 100}`)
+	require.Equal(t, 50, ConfigValue(c.Get(ctx), "batch_size", 10))
+	require.Equal(t, 2, db.loads)
+
+	// the last known config is kept on errors
+	db.err = errors.New("db is down")
+	c.Invalidate()
+	require.Equal(t, 50, ConfigValue(c.Get(ctx), "batch_size", 10))
+	require.Equal(t, 3, db.loads)
+
+	// failed load is not retried until TTL
+	db.err = nil
+	require.Equal(t, 50, ConfigValue(c.Get(ctx), "batch_size", 10))
+	require.Equal(t, 3, db.loads)
+	c.Invalidate()
+	require.Equal(t, 100, ConfigValue(c.Get(ctx), "batch_size", 10))
+}
+
+// slowConfigDatabase serves GetConfig after a delay and counts loads.
+type slowConfigDatabase struct {
+	Database
+	loads atomic.Int32
+}
+
+func (db *slowConfigDatabase) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	db.loads.Add(1)
+	time.Sleep(10 * time.Millisecond)
+	return json.RawMessage(`{"batch_size": 50}`), nil
+}
+
+func TestConfigCacheConcurrent(t *testing.T) {
+	ctx := context.Background()
+	db := &slowConfigDatabase{}
+	c := NewConfigCache(db, "test-config", time.Hour)
+
+	// concurrent calls wait for the first load instead of loading the config again
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Equal(t, 50, ConfigValue(c.Get(ctx), "batch_size", 10))
+		}()
+	}
+	wg.Wait()
+	require.Equal(t, int32(1), db.loads.Load())
+}
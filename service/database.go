@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/athenianco/cloud-common/dbs"
 )
@@ -11,11 +13,37 @@ type Service struct {
 	Enabled bool
 }
 
+// Instance is a running instance of a service.
+type Instance struct {
+	// Service is the name of the service.
+	Service string
+	// ID is a unique instance ID, see report.InstanceID.
+	ID string
+	// Version is a deployed version of the service.
+	Version string
+	// StartedAt is the time when the instance started.
+	StartedAt time.Time
+	// HeartbeatAt is the time of the last heartbeat of the instance.
+	HeartbeatAt time.Time
+}
+
 type Database interface {
 	RegisterService(ctx context.Context, name string) (bool, error)
 	SwitchService(ctx context.Context, name string, enabled bool) error
 	GetService(ctx context.Context, name string) (*Service, error)
 	ListServices(ctx context.Context) (Iterator, error)
+	// GetConfig returns the JSON configuration of the service. It returns dbs.ErrNotFound if the service is not registered.
+	GetConfig(ctx context.Context, name string) (json.RawMessage, error)
+	// SetConfig replaces the JSON configuration of the service. It returns dbs.ErrNotFound if the service is not registered.
+	SetConfig(ctx context.Context, name string, conf json.RawMessage) error
+	// RegisterInstance creates or updates the instance of the service and sets its heartbeat to the current time.
+	RegisterInstance(ctx context.Context, inst *Instance) error
+	// Heartbeat updates the heartbeat of the instance. It returns dbs.ErrNotFound if the instance is not registered.
+	Heartbeat(ctx context.Context, service, id string) error
+	// ListInstances lists instances of the service, or of all services, if the name is empty.
+	ListInstances(ctx context.Context, service string) (InstanceIterator, error)
+	// PruneInstances removes instances with the last heartbeat before a given time and returns their number.
+	PruneInstances(ctx context.Context, before time.Time) (int, error)
 	Close() error
 }
 
@@ -28,3 +56,8 @@ type Iterator interface {
 	dbs.IteratorBase
 	Value() *Service
 }
+
+type InstanceIterator interface {
+	dbs.IteratorBase
+	Value() *Instance
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/athenianco/cloud-common/dbs"
+)
+
+var _ TestDatabase = (*MemDatabase)(nil)
+
+// NewMemDatabase creates an in-memory Database that is useful for testing.
+func NewMemDatabase() *MemDatabase {
+	return &MemDatabase{
+		services:  make(map[string]*memService),
+		instances: make(map[instanceKey]Instance),
+	}
+}
+
+// MemDatabase is an in-memory Database implementation. It's safe for concurrent use.
+type MemDatabase struct {
+	mu        sync.Mutex
+	services  map[string]*memService
+	instances map[instanceKey]Instance
+}
+
+type memService struct {
+	enabled bool
+	config  json.RawMessage
+}
+
+type instanceKey struct {
+	service string
+	id      string
+}
+
+func (db *MemDatabase) RegisterService(ctx context.Context, name string) (bool, error) {
+	if name == "" {
+		return false, errors.New("service name must be set")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if svc, ok := db.services[name]; ok {
+		return svc.enabled, nil
+	}
+	db.services[name] = &memService{enabled: true, config: json.RawMessage("{}")}
+	return true, nil
+}
+
+func (db *MemDatabase) SwitchService(ctx context.Context, name string, enabled bool) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	svc, ok := db.services[name]
+	if !ok {
+		return dbs.ErrNotFound
+	}
+	svc.enabled = enabled
+	return nil
+}
+
+func (db *MemDatabase) GetService(ctx context.Context, name string) (*Service, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	svc, ok := db.services[name]
+	if !ok {
+		return nil, dbs.ErrNotFound
+	}
+	return &Service{Name: name, Enabled: svc.enabled}, nil
+}
+
+func (db *MemDatabase) ListServices(ctx context.Context) (Iterator, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	list := make([]Service, 0, len(db.services))
+	for name, svc := range db.services {
+		list = append(list, Service{Name: name, Enabled: svc.enabled})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return &memIter[Service]{list: list}, nil
+}
+
+func (db *MemDatabase) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	svc, ok := db.services[name]
+	if !ok {
+		return nil, dbs.ErrNotFound
+	}
+	return append(json.RawMessage(nil), svc.config...), nil
+}
+
+func (db *MemDatabase) SetConfig(ctx context.Context, name string, conf json.RawMessage) error {
+	if len(conf) == 0 {
+		conf = json.RawMessage("{}")
+	} else if !json.Valid(conf) {
+		return errors.New("service config is not a valid JSON")
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	svc, ok := db.services[name]
+	if !ok {
+		return dbs.ErrNotFound
+	}
+	svc.config = append(json.RawMessage(nil), conf...)
+	return nil
+}
+
+func (db *MemDatabase) RegisterInstance(ctx context.Context, inst *Instance) error {
+	if inst.Service == "" || inst.ID == "" {
+		return errors.New("service name and instance id must be set")
+	}
+	out := *inst
+	if out.StartedAt.IsZero() {
+		out.StartedAt = time.Now()
+	}
+	out.StartedAt = out.StartedAt.UTC()
+	out.HeartbeatAt = time.Now().UTC()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.instances[instanceKey{service: out.Service, id: out.ID}] = out
+	return nil
+}
+
+func (db *MemDatabase) Heartbeat(ctx context.Context, service, id string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	key := instanceKey{service: service, id: id}
+	inst, ok := db.instances[key]
+	if !ok {
+		return dbs.ErrNotFound
+	}
+	inst.HeartbeatAt = time.Now().UTC()
+	db.instances[key] = inst
+	return nil
+}
+
+func (db *MemDatabase) ListInstances(ctx context.Context, service string) (InstanceIterator, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var list []Instance
+	for _, inst := range db.instances {
+		if service == "" || inst.Service == service {
+			list = append(list, inst)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		a, b := list[i], list[j]
+		if a.Service != b.Service {
+			return a.Service < b.Service
+		}
+		return a.StartedAt.Before(b.StartedAt)
+	})
+	return &memIter[Instance]{list: list}, nil
+}
+
+func (db *MemDatabase) PruneInstances(ctx context.Context, before time.Time) (int, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	n := 0
+	for key, inst := range db.instances {
+		if inst.HeartbeatAt.Before(before) {
+			delete(db.instances, key)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (db *MemDatabase) Cleanup(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.services = make(map[string]*memService)
+	db.instances = make(map[instanceKey]Instance)
+	return nil
+}
+
+func (db *MemDatabase) Close() error {
+	return nil
+}
+
+// memIter iterates over a snapshot of values.
+type memIter[T any] struct {
+	list []T
+	cur  *T
+}
+
+func (it *memIter[T]) Next() bool {
+	if len(it.list) == 0 {
+		it.cur = nil
+		return false
+	}
+	it.cur = &it.list[0]
+	it.list = it.list[1:]
+	return true
+}
+
+func (it *memIter[T]) Value() *T {
+	return it.cur
+}
+
+func (it *memIter[T]) Err() error {
+	return nil
+}
+
+func (it *memIter[T]) Close() error {
+	it.list = nil
+	return nil
+}
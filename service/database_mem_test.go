@@ -0,0 +1,18 @@
+package service_test
+
+import (
+	"testing"
+
+	"github.com/athenianco/cloud-common/service"
+	"github.com/athenianco/cloud-common/service/servicetest"
+)
+
+func makeMemDatabase(t testing.TB) (servicetest.DBFunc, func()) {
+	return func(t testing.TB) (service.TestDatabase, func()) {
+		return service.NewMemDatabase(), func() {}
+	}, func() {}
+}
+
+func TestMemDatabase(t *testing.T) {
+	servicetest.RunDatabaseTest(t, makeMemDatabase)
+}
@@ -3,140 +3,201 @@ package service
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"os"
-	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v4"
-	"github.com/jackc/pgx/v4/pgxpool"
 
 	"github.com/athenianco/cloud-common/dbs"
-)
-
-const (
-	pgConnMaxLifetime = time.Minute
-	pgConnMaxIdleTime = 30 * time.Second
+	"github.com/athenianco/cloud-common/dbs/pg"
 )
 
 var _ Database = (*pgDatabase)(nil)
 
 // pgDatabase is a postgres database where services info is stored.
 type pgDatabase struct {
-	db *pgxpool.Pool
+	db *pg.Pool
 }
 
 // OpenDatabaseFromEnv opens default postgres database based on environment variable:
 // SERVICE_DATABASE_URI
+//
+// It fails if the schema is behind, see Migrate.
 func OpenDatabaseFromEnv() (Database, error) {
 	dbURI := os.Getenv("SERVICE_DATABASE_URI")
 	if dbURI == "" {
 		return nil, errors.New("SERVICE_DATABASE_URI is not set")
 	}
 
-	db, err := OpenDatabase(context.Background(), dbURI)
+	ctx := context.Background()
+	db, err := openDatabase(ctx, dbURI)
 	if err != nil {
 		return nil, err
 	}
+	if err = migrations.StartupCheck(ctx, db.db); err != nil {
+		db.Close()
+		return nil, err
+	}
 	return db, nil
 }
 
-func OpenDatabase(ctx context.Context, dbURI string) (Database, error) {
-	return openDatabase(ctx, dbURI)
+func OpenDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (Database, error) {
+	return openDatabase(ctx, dbURI, opts...)
 }
 
-func OpenTestDatabase(ctx context.Context, dbURI string) (TestDatabase, error) {
-	return openDatabase(ctx, dbURI)
+func OpenTestDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (TestDatabase, error) {
+	return openDatabase(ctx, dbURI, opts...)
 }
 
 // openDatabase opens postgres connection
-func openDatabase(ctx context.Context, dbURI string) (*pgDatabase, error) {
-	config, err := pgxpool.ParseConfig(processAddress(dbURI))
-	if err != nil {
-		return nil, err
-	}
-	config.ConnConfig.PreferSimpleProtocol = true
-	config.MaxConnLifetime = pgConnMaxLifetime
-	config.MaxConnIdleTime = pgConnMaxIdleTime
-
-	conn, err := pgxpool.ConnectConfig(ctx, config)
+func openDatabase(ctx context.Context, dbURI string, opts ...pg.Option) (*pgDatabase, error) {
+	conn, err := pg.Open(ctx, "service", dbURI, opts...)
 	if err != nil {
 		return nil, err
 	}
 	return &pgDatabase{db: conn}, nil
 }
 
+// RegisterService is safe to call concurrently: if another instance registers the service first,
+// the transaction is retried and returns the state of the existing service.
 func (db *pgDatabase) RegisterService(ctx context.Context, name string) (bool, error) {
-	tx, err := db.db.BeginTx(ctx, pgx.TxOptions{})
+	var enabled bool
+	err := dbs.InTx(ctx, db.db, dbs.TxOptions{}, func(tx pgx.Tx) error {
+		svc, err := db.getService(ctx, tx, name)
+		if err == nil {
+			enabled = svc.Enabled
+			return nil
+		} else if err != dbs.ErrNotFound {
+			return err
+		}
+		_, err = tx.Exec(ctx, `INSERT INTO services(name) VALUES($1);`, newNullString(name))
+		enabled = true
+		return err
+	})
 	if err != nil {
 		return false, err
 	}
-	defer tx.Rollback(ctx)
+	return enabled, nil
+}
 
-	svc, err := db.getService(ctx, tx, name)
-	if err != nil && err != dbs.ErrNotFound {
-		return false, err
-	} else if err == nil {
-		return svc.Enabled, tx.Commit(ctx)
-	}
-	_, err = tx.Exec(ctx, `INSERT INTO services(name) VALUES($1);`, newNullString(name))
+func (db *pgDatabase) SwitchService(ctx context.Context, name string, enabled bool) error {
+	return dbs.InTx(ctx, db.db, dbs.TxOptions{}, func(tx pgx.Tx) error {
+		svc, err := db.getService(ctx, tx, name)
+		if err != nil {
+			return err
+		}
+		if enabled == svc.Enabled {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `UPDATE services SET enabled = $2 WHERE name = $1;`, newNullString(name), enabled)
+		return err
+	})
+}
+
+func (db *pgDatabase) GetService(ctx context.Context, name string) (*Service, error) {
+	return db.getService(ctx, db.db, name)
+}
+
+func (db *pgDatabase) ListServices(ctx context.Context) (Iterator, error) {
+	it, err := dbs.Query(ctx, db.db, scanService, `SELECT name, enabled FROM services;`)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return true, tx.Commit(ctx)
+	return it, nil
 }
 
-func (db *pgDatabase) SwitchService(ctx context.Context, name string, enabled bool) error {
-	tx, err := db.db.BeginTx(ctx, pgx.TxOptions{})
+func (db *pgDatabase) GetConfig(ctx context.Context, name string) (json.RawMessage, error) {
+	conf, err := dbs.QueryOne(ctx, db.db, func(sc dbs.Scanner) (string, error) {
+		var conf string
+		err := sc.Scan(&conf)
+		return conf, err
+	}, `SELECT config::text FROM services WHERE name = $1;`, newNullString(name))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer tx.Rollback(ctx)
+	return json.RawMessage(conf), nil
+}
 
-	svc, err := db.getService(ctx, tx, name)
+func (db *pgDatabase) SetConfig(ctx context.Context, name string, conf json.RawMessage) error {
+	if len(conf) == 0 {
+		conf = json.RawMessage("{}")
+	} else if !json.Valid(conf) {
+		return errors.New("service config is not a valid JSON")
+	}
+	tag, err := db.db.Exec(ctx, `UPDATE services SET config = $2::jsonb WHERE name = $1;`, newNullString(name), string(conf))
 	if err != nil {
 		return err
+	} else if tag.RowsAffected() == 0 {
+		return dbs.ErrNotFound
 	}
-	if enabled == svc.Enabled {
-		return nil
+	return nil
+}
+
+func (db *pgDatabase) RegisterInstance(ctx context.Context, inst *Instance) error {
+	if inst.Service == "" || inst.ID == "" {
+		return errors.New("service name and instance id must be set")
 	}
-	if _, err := tx.Exec(ctx, `UPDATE services SET enabled = $2 WHERE name = $1;`, newNullString(name), enabled); err != nil {
-		return err
+	startedAt := inst.StartedAt
+	if startedAt.IsZero() {
+		startedAt = time.Now()
 	}
-	return tx.Commit(ctx)
+	_, err := db.db.Exec(ctx, `
+INSERT INTO service_instances(service, instance_id, version, started_at, heartbeat_at)
+VALUES($1, $2, $3, $4, now())
+ON CONFLICT (service, instance_id) DO UPDATE
+SET version = EXCLUDED.version, started_at = EXCLUDED.started_at, heartbeat_at = EXCLUDED.heartbeat_at;`,
+		inst.Service, inst.ID, inst.Version, startedAt.UTC())
+	return err
 }
 
-func (db *pgDatabase) GetService(ctx context.Context, name string) (*Service, error) {
-	tx, err := db.db.BeginTx(ctx, pgx.TxOptions{})
+func (db *pgDatabase) Heartbeat(ctx context.Context, service, id string) error {
+	tag, err := db.db.Exec(ctx, `UPDATE service_instances SET heartbeat_at = now() WHERE service = $1 AND instance_id = $2;`, service, id)
 	if err != nil {
-		return nil, err
+		return err
+	} else if tag.RowsAffected() == 0 {
+		return dbs.ErrNotFound
+	}
+	return nil
+}
+
+func (db *pgDatabase) ListInstances(ctx context.Context, service string) (InstanceIterator, error) {
+	const query = `SELECT service, instance_id, version, started_at, heartbeat_at FROM service_instances`
+	var (
+		it  *dbs.Iterator[Instance]
+		err error
+	)
+	if service == "" {
+		it, err = dbs.Query(ctx, db.db, scanInstance, query+` ORDER BY service, started_at;`)
+	} else {
+		it, err = dbs.Query(ctx, db.db, scanInstance, query+` WHERE service = $1 ORDER BY started_at;`, service)
 	}
-	defer tx.Rollback(ctx)
-	svc, err := db.getService(ctx, tx, name)
 	if err != nil {
 		return nil, err
 	}
-	return svc, tx.Commit(ctx)
+	return it, nil
 }
 
-func (db *pgDatabase) ListServices(ctx context.Context) (Iterator, error) {
-	rows, err := db.db.Query(ctx, `SELECT name, enabled FROM services;`)
+func (db *pgDatabase) PruneInstances(ctx context.Context, before time.Time) (int, error) {
+	tag, err := db.db.Exec(ctx, `DELETE FROM service_instances WHERE heartbeat_at < $1;`, before.UTC())
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	return &serviceIter{
-		rows: rows,
-	}, nil
+	return int(tag.RowsAffected()), nil
 }
 
 func (db *pgDatabase) Cleanup(ctx context.Context) error {
-	_, err := db.db.Exec(ctx, `DELETE FROM services;`)
+	_, err := db.db.Exec(ctx, `DELETE FROM service_instances;`)
+	if err != nil {
+		return err
+	}
+	_, err = db.db.Exec(ctx, `DELETE FROM services;`)
 	return err
 }
 
-func (db *pgDatabase) getService(ctx context.Context, tx pgx.Tx, name string) (*Service, error) {
-	row := tx.QueryRow(ctx, `SELECT name, enabled FROM services WHERE name = $1;`, name)
-	svc, err := scanService(row)
+func (db *pgDatabase) getService(ctx context.Context, q dbs.Querier, name string) (*Service, error) {
+	svc, err := dbs.QueryOne(ctx, q, scanService, `SELECT name, enabled FROM services WHERE name = $1;`, name)
 	if err != nil {
 		return nil, err
 	}
@@ -154,53 +215,16 @@ func scanService(sc dbs.Scanner) (Service, error) {
 		enabled bool
 	)
 	err := sc.Scan(&name, &enabled)
-	if err == pgx.ErrNoRows {
-		err = dbs.ErrNotFound
-	}
-
 	return Service{
 		Name:    name,
 		Enabled: enabled,
 	}, err
 }
 
-type serviceIter struct {
-	rows    pgx.Rows
-	current *Service
-
-	err error
-}
-
-func (it *serviceIter) Next() bool {
-	if it.err != nil {
-		return false
-	}
-	if !it.rows.Next() {
-		return false
-	}
-	iss, err := scanService(it.rows)
-	if err != nil {
-		it.err = err
-		return false
-	}
-	it.current = &iss
-	return true
-}
-
-func (it *serviceIter) Value() *Service {
-	if it.err != nil {
-		return nil
-	}
-	return it.current
-}
-
-func (it *serviceIter) Err() error {
-	return it.err
-}
-
-func (it *serviceIter) Close() error {
-	it.rows.Close()
-	return it.err
+func scanInstance(sc dbs.Scanner) (Instance, error) {
+	var inst Instance
+	err := sc.Scan(&inst.Service, &inst.ID, &inst.Version, &inst.StartedAt, &inst.HeartbeatAt)
+	return inst, err
 }
 
 func newNullString(s string) sql.NullString {
@@ -212,7 +236,3 @@ func newNullString(s string) sql.NullString {
 		Valid:  true,
 	}
 }
-
-func processAddress(addr string) string {
-	return strings.Replace(addr, "&binary_parameters=yes", "", -1)
-}
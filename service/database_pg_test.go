@@ -4,8 +4,6 @@ import (
 	"context"
 	"testing"
 
-	"github.com/jackc/pgx/v4/pgxpool"
-
 	"github.com/athenianco/cloud-common/dbs/pgtest"
 	"github.com/athenianco/cloud-common/service"
 	"github.com/athenianco/cloud-common/service/servicetest"
@@ -13,20 +11,7 @@ import (
 
 func makeDatabasePool(t testing.TB) (servicetest.DBFunc, func()) {
 	pool, closer := pgtest.NewDatabasePoolWith(t, func(addr string) error {
-		ctx := context.Background()
-		conn, err := pgxpool.Connect(ctx, addr)
-		if err != nil {
-			return err
-		}
-		defer conn.Close()
-		_, err = conn.Exec(ctx, `
-CREATE TABLE services (
-    name text NOT NULL,
-    enabled bool NOT NULL DEFAULT TRUE,
-    PRIMARY KEY(name)
-);
-`)
-		return err
+		return service.Migrate(context.Background(), addr)
 	})
 
 	return func(t testing.TB) (service.TestDatabase, func()) {
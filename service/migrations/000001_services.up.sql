@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS services (
+    name text NOT NULL,
+    enabled bool NOT NULL DEFAULT TRUE,
+    PRIMARY KEY(name)
+);
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS service_instances (
+    service text NOT NULL,
+    instance_id text NOT NULL,
+    version text NOT NULL DEFAULT '',
+    started_at timestamptz NOT NULL,
+    heartbeat_at timestamptz NOT NULL,
+    PRIMARY KEY(service, instance_id)
+);
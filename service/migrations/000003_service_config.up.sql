@@ -0,0 +1 @@
+ALTER TABLE services ADD COLUMN IF NOT EXISTS config jsonb NOT NULL DEFAULT '{}';
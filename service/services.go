@@ -3,8 +3,36 @@ package service
 import (
 	"context"
 	"os"
+	"time"
+
+	"github.com/athenianco/cloud-common/envs"
+	"github.com/athenianco/cloud-common/report"
 )
 
+// startedAt is the start time of the current instance.
+var startedAt = time.Now()
+
+// Version returns the deployed version of the service, if it's set.
+// It's taken from SERVICE_VERSION or K_REVISION (which is set by Cloud Run).
+func Version() string {
+	return envs.OneOfEnvs("SERVICE_VERSION", "K_REVISION")
+}
+
+// CurrentInstance returns the information about the current instance of the service.
+func CurrentInstance(name string) *Instance {
+	return &Instance{
+		Service:   name,
+		ID:        report.InstanceID(),
+		Version:   Version(),
+		StartedAt: startedAt,
+	}
+}
+
+// Register registers the service and its current instance in the database.
+// The service name and the database are taken from SERVICE_NAME and SERVICE_DATABASE_URI,
+// if either is not set, the service is not regulated and is considered enabled.
+//
+// It returns false if the service is disabled.
 func Register(ctx context.Context) (bool, error) {
 	name := os.Getenv("SERVICE_NAME")
 	dbURI := os.Getenv("SERVICE_DATABASE_URI")
@@ -17,5 +45,17 @@ func Register(ctx context.Context) (bool, error) {
 		return false, err
 	}
 	defer db.Close()
-	return db.RegisterService(ctx, name)
+	return RegisterWith(ctx, db, name)
+}
+
+// RegisterWith is similar to Register, but uses a given database and service name.
+func RegisterWith(ctx context.Context, db Database, name string) (bool, error) {
+	enabled, err := db.RegisterService(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if err = db.RegisterInstance(ctx, CurrentInstance(name)); err != nil {
+		return false, err
+	}
+	return enabled, nil
 }
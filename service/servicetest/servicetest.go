@@ -2,9 +2,13 @@ package servicetest
 
 import (
 	"context"
+	"encoding/json"
 	"strconv"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/athenianco/cloud-common/dbs"
@@ -21,8 +25,11 @@ func RunDatabaseTest(t *testing.T, pool DBServerFunc) {
 		run  func(testing.TB, service.TestDatabase)
 	}{
 		{"RegisterService", testRegisterGetService},
+		{"RegisterServiceConcurrent", testRegisterServiceConcurrent},
 		{"EnableDisableService", testEnableDisableService},
 		{"ListServices", testListServices},
+		{"Instances", testInstances},
+		{"Config", testConfig},
 	}
 
 	fnc, closer := pool(t)
@@ -68,6 +75,40 @@ func testRegisterGetService(t testing.TB, db service.TestDatabase) {
 	get("svc1", true)
 }
 
+func testRegisterServiceConcurrent(t testing.TB, db service.TestDatabase) {
+	ctx := context.Background()
+	const n = 10
+
+	register := func(name string, exp bool) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				enabled, err := db.RegisterService(ctx, name)
+				assert.NoError(t, err)
+				assert.Equal(t, exp, enabled)
+			}()
+		}
+		wg.Wait()
+	}
+	for i := 0; i < 3; i++ {
+		register("svc"+strconv.Itoa(i), true)
+	}
+	require.NoError(t, db.SwitchService(ctx, "svc0", false))
+	register("svc0", false)
+
+	it, err := db.ListServices(ctx)
+	require.NoError(t, err)
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		count++
+	}
+	require.NoError(t, it.Err())
+	require.Equal(t, 3, count)
+}
+
 func testEnableDisableService(t testing.TB, db service.TestDatabase) {
 	ctx := context.Background()
 
@@ -170,3 +211,98 @@ func testListServices(t testing.TB, db service.TestDatabase) {
 	disable("svc3")
 	checkServices()
 }
+
+func testInstances(t testing.TB, db service.TestDatabase) {
+	ctx := context.Background()
+	start := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+
+	reg := func(svc, id, version string, started time.Time) {
+		err := db.RegisterInstance(ctx, &service.Instance{
+			Service: svc, ID: id, Version: version, StartedAt: started,
+		})
+		require.NoError(t, err)
+	}
+	list := func(svc string) []service.Instance {
+		it, err := db.ListInstances(ctx, svc)
+		require.NoError(t, err)
+		defer it.Close()
+
+		var out []service.Instance
+		for it.Next() {
+			inst := *it.Value()
+			require.False(t, inst.HeartbeatAt.IsZero())
+			require.False(t, inst.HeartbeatAt.Before(inst.StartedAt))
+			inst.StartedAt = inst.StartedAt.UTC()
+			inst.HeartbeatAt = time.Time{}
+			out = append(out, inst)
+		}
+		require.NoError(t, it.Err())
+		return out
+	}
+
+	require.Error(t, db.RegisterInstance(ctx, &service.Instance{Service: "svc"}))
+	require.Equal(t, dbs.ErrNotFound, db.Heartbeat(ctx, "svc", "a"))
+	require.Empty(t, list(""))
+
+	reg("svc", "a", "v1", start)
+	reg("svc", "b", "v1", start.Add(time.Minute))
+	reg("svc1", "a", "v2", start)
+	require.Equal(t, []service.Instance{
+		{Service: "svc", ID: "a", Version: "v1", StartedAt: start},
+		{Service: "svc", ID: "b", Version: "v1", StartedAt: start.Add(time.Minute)},
+	}, list("svc"))
+	require.Len(t, list(""), 3)
+	require.Empty(t, list("svc2"))
+
+	// re-registering updates the instance
+	reg("svc", "a", "v2", start.Add(2*time.Minute))
+	require.Equal(t, []service.Instance{
+		{Service: "svc", ID: "b", Version: "v1", StartedAt: start.Add(time.Minute)},
+		{Service: "svc", ID: "a", Version: "v2", StartedAt: start.Add(2 * time.Minute)},
+	}, list("svc"))
+
+	require.NoError(t, db.Heartbeat(ctx, "svc", "a"))
+	require.Equal(t, dbs.ErrNotFound, db.Heartbeat(ctx, "svc", "c"))
+
+	n, err := db.PruneInstances(ctx, time.Now().Add(-time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+	require.Len(t, list(""), 3)
+
+	n, err = db.PruneInstances(ctx, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	require.Equal(t, 3, n)
+	require.Empty(t, list(""))
+	require.Equal(t, dbs.ErrNotFound, db.Heartbeat(ctx, "svc", "a"))
+}
+
+func testConfig(t testing.TB, db service.TestDatabase) {
+	ctx := context.Background()
+
+	_, err := db.GetConfig(ctx, "svc")
+	require.Equal(t, dbs.ErrNotFound, err)
+	require.Equal(t, dbs.ErrNotFound, db.SetConfig(ctx, "svc", json.RawMessage(`{}`)))
+
+	_, err = db.RegisterService(ctx, "svc")
+	require.NoError(t, err)
+	conf, err := db.GetConfig(ctx, "svc")
+	require.NoError(t, err)
+	require.JSONEq(t, `{}`, string(conf))
+
+	require.Error(t, db.SetConfig(ctx, "svc", json.RawMessage(`{`)))
+	require.NoError(t, db.SetConfig(ctx, "svc", json.RawMessage(`{"batch_size": 50, "dry_run": true}`)))
+	conf, err = db.GetConfig(ctx, "svc")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"batch_size": 50, "dry_run": true}`, string(conf))
+
+	// config doesn't affect the state
+	require.NoError(t, db.SwitchService(ctx, "svc", false))
+	conf, err = db.GetConfig(ctx, "svc")
+	require.NoError(t, err)
+	require.JSONEq(t, `{"batch_size": 50, "dry_run": true}`, string(conf))
+
+	require.NoError(t, db.SetConfig(ctx, "svc", nil))
+	conf, err = db.GetConfig(ctx, "svc")
+	require.NoError(t, err)
+	require.JSONEq(t, `{}`, string(conf))
+}
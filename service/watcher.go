@@ -0,0 +1,303 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/pubsub"
+	"github.com/athenianco/cloud-common/report"
+)
+
+// DefaultPollInterval is the default interval between service state checks.
+const DefaultPollInterval = 30 * time.Second
+
+const (
+	labelService = "service"
+	labelState   = "state"
+
+	stateEnabled  = "enabled"
+	stateDisabled = "disabled"
+)
+
+var (
+	serviceEnabled = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "athenian_service_enabled",
+		Help: "Whether the service is enabled (1) or disabled (0)",
+	}, []string{labelService})
+	countTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "athenian_service_transitions_count",
+		Help: "The count of service state transitions",
+	}, []string{labelService, labelState})
+)
+
+// ErrDisabled is returned by middlewares when the service is disabled.
+// It's temporary, so the work is retried later.
+var ErrDisabled error = disabledError{}
+
+type disabledError struct{}
+
+func (disabledError) Error() string   { return "service is disabled" }
+func (disabledError) Temporary() bool { return true }
+
+// NewWatcherFromEnv creates a watcher for a service based on environment variables:
+// SERVICE_NAME, SERVICE_DATABASE_URI and, optionally, SERVICE_POLL_INTERVAL.
+// It returns nil if the service is not regulated.
+//
+// The watcher owns the database and closes it on Close.
+func NewWatcherFromEnv() (*Watcher, error) {
+	name := os.Getenv("SERVICE_NAME")
+	if name == "" || os.Getenv("SERVICE_DATABASE_URI") == "" {
+		// service is not regulated
+		return nil, nil
+	}
+	db, err := OpenDatabaseFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWatcherWith(db, name)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	w.ownDB = true
+	return w, nil
+}
+
+// RegisterFromEnv is similar to Register, but also creates a watcher for the service, see NewWatcherFromEnv.
+// Both share a single database connection, which is closed by the watcher.
+//
+// The watcher is nil if the service is not regulated. Otherwise, it's returned even if the service is disabled,
+// and its initial state is the one returned by the registration.
+func RegisterFromEnv(ctx context.Context) (bool, *Watcher, error) {
+	name := os.Getenv("SERVICE_NAME")
+	if name == "" || os.Getenv("SERVICE_DATABASE_URI") == "" {
+		// service is not regulated
+		return true, nil, nil
+	}
+	db, err := OpenDatabaseFromEnv()
+	if err != nil {
+		return false, nil, err
+	}
+	enabled, err := RegisterWith(ctx, db, name)
+	if err != nil {
+		db.Close()
+		return false, nil, err
+	}
+	w, err := NewWatcherWith(db, name)
+	if err != nil {
+		db.Close()
+		return false, nil, err
+	}
+	w.ownDB = true
+	w.init(enabled)
+	return enabled, w, nil
+}
+
+// NewWatcherWith is similar to NewWatcherFromEnv, but uses a given database and service name.
+// The database is not closed by the watcher.
+func NewWatcherWith(db Database, name string) (*Watcher, error) {
+	interval := DefaultPollInterval
+	if s := os.Getenv("SERVICE_POLL_INTERVAL"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SERVICE_POLL_INTERVAL: %w", err)
+		}
+		interval = d
+	}
+	w := NewWatcher(db, name, interval)
+	w.instance = CurrentInstance(name)
+	return w, nil
+}
+
+// NewWatcher creates a watcher that checks if the service is enabled every interval.
+// The service is assumed to be enabled until the first check.
+func NewWatcher(db Database, name string, interval time.Duration) *Watcher {
+	if interval <= 0 {
+		interval = DefaultPollInterval
+	}
+	w := &Watcher{
+		db:       db,
+		name:     name,
+		interval: interval,
+		config:   NewConfigCache(db, name, interval),
+		stop:     make(chan struct{}),
+	}
+	w.init(true)
+	return w
+}
+
+// Watcher tracks the enabled state of the service at runtime.
+type Watcher struct {
+	db       Database
+	ownDB    bool
+	name     string
+	interval time.Duration
+	instance *Instance // sends heartbeats for the instance, if set
+	config   *ConfigCache
+
+	mu         sync.RWMutex
+	enabled    bool
+	checked    time.Time // last check, including failed ones
+	refreshing bool      // Refresh is polling the state
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// Enabled returns the last known state of the service.
+func (w *Watcher) Enabled() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.enabled
+}
+
+// init sets the initial state of the service, without counting it as a transition.
+func (w *Watcher) init(enabled bool) {
+	w.mu.Lock()
+	w.enabled = enabled
+	w.mu.Unlock()
+	val := 0.0
+	if enabled {
+		val = 1.0
+	}
+	serviceEnabled.WithLabelValues(w.name).Set(val)
+}
+
+func (w *Watcher) set(ctx context.Context, enabled bool) {
+	w.mu.Lock()
+	prev := w.enabled
+	w.enabled = enabled
+	w.checked = time.Now()
+	w.mu.Unlock()
+	if prev == enabled {
+		return
+	}
+	state, val := stateDisabled, 0.0
+	if enabled {
+		state, val = stateEnabled, 1.0
+	}
+	serviceEnabled.WithLabelValues(w.name).Set(val)
+	countTransitions.WithLabelValues(w.name, state).Inc()
+	report.Info(ctx, "service %s: %s", w.name, state)
+}
+
+// Poll checks the service state in the database.
+// A service that is not registered is considered enabled.
+//
+// If the watcher was created with NewWatcherFromEnv, it also sends a heartbeat for the current instance.
+func (w *Watcher) Poll(ctx context.Context) error {
+	svc, err := w.db.GetService(ctx, w.name)
+	if errors.Is(err, dbs.ErrNotFound) {
+		w.set(ctx, true)
+	} else if err != nil {
+		return err
+	} else {
+		w.set(ctx, svc.Enabled)
+	}
+	if w.instance == nil {
+		return nil
+	}
+	err = w.db.Heartbeat(ctx, w.instance.Service, w.instance.ID)
+	if errors.Is(err, dbs.ErrNotFound) {
+		// the instance was pruned
+		err = w.db.RegisterInstance(ctx, w.instance)
+	}
+	if err != nil {
+		return fmt.Errorf("heartbeat: %w", err)
+	}
+	return nil
+}
+
+// Config returns the service config. It's cached for the poll interval.
+func (w *Watcher) Config(ctx context.Context) Config {
+	return w.config.Get(ctx)
+}
+
+// Refresh polls the service state if the last check is older than the interval, and returns the state.
+// It's useful when background polling is not possible.
+//
+// Only one call polls the state at a time, concurrent calls return the last known state immediately.
+// The last known state is also kept if the check fails, and the check is retried after the interval.
+func (w *Watcher) Refresh(ctx context.Context) bool {
+	w.mu.Lock()
+	if w.refreshing || time.Since(w.checked) < w.interval {
+		enabled := w.enabled
+		w.mu.Unlock()
+		return enabled
+	}
+	w.refreshing = true
+	w.mu.Unlock()
+
+	err := w.Poll(ctx)
+
+	w.mu.Lock()
+	w.refreshing = false
+	if err != nil {
+		w.checked = time.Now()
+	}
+	enabled := w.enabled
+	w.mu.Unlock()
+	if err != nil {
+		report.Error(ctx, fmt.Errorf("cannot check service state: %w", err))
+	}
+	return enabled
+}
+
+// Run polls the service state until ctx is cancelled or the watcher is closed.
+func (w *Watcher) Run(ctx context.Context) {
+	t := time.NewTicker(w.interval)
+	defer t.Stop()
+	for {
+		if err := w.Poll(ctx); err != nil && ctx.Err() == nil {
+			report.Error(ctx, fmt.Errorf("cannot check service state: %w", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.stop:
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// Close stops Run and closes the database, if it's owned by the watcher.
+func (w *Watcher) Close() error {
+	w.stopOnce.Do(func() {
+		close(w.stop)
+	})
+	if w.ownDB {
+		return w.db.Close()
+	}
+	return nil
+}
+
+// HTTPMiddleware rejects requests with 503 while the service is disabled.
+func (w *Watcher) HTTPMiddleware(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !w.Enabled() {
+			http.Error(rw, ErrDisabled.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// Handler nacks messages with ErrDisabled while the service is disabled.
+func (w *Watcher) Handler(h pubsub.Handler) pubsub.Handler {
+	return func(ctx context.Context, msg pubsub.Message) error {
+		if !w.Enabled() {
+			return ErrDisabled
+		}
+		return h(ctx, msg)
+	}
+}
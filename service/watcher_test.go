@@ -0,0 +1,164 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+
+	"github.com/athenianco/cloud-common/dbs"
+	"github.com/athenianco/cloud-common/pubsub"
+)
+
+// stateDatabase is a minimal Database that only serves GetService.
+type stateDatabase struct {
+	Database
+	mu    sync.Mutex
+	svcs  map[string]bool
+	err   error
+	calls int
+}
+
+func (db *stateDatabase) getCalls() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.calls
+}
+
+func (db *stateDatabase) switchService(name string, enabled bool) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.svcs[name] = enabled
+}
+
+func (db *stateDatabase) GetService(ctx context.Context, name string) (*Service, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.calls++
+	if db.err != nil {
+		return nil, db.err
+	}
+	enabled, ok := db.svcs[name]
+	if !ok {
+		return nil, dbs.ErrNotFound
+	}
+	return &Service{Name: name, Enabled: enabled}, nil
+}
+
+func TestWatcher(t *testing.T) {
+	ctx := context.Background()
+	db := &stateDatabase{svcs: map[string]bool{}}
+	const interval = 100 * time.Millisecond
+	w := NewWatcher(db, "test-watcher", interval)
+	require.True(t, w.Enabled())
+	disabled := testutil.ToFloat64(countTransitions.WithLabelValues("test-watcher", stateDisabled))
+	enabled := testutil.ToFloat64(countTransitions.WithLabelValues("test-watcher", stateEnabled))
+
+	// not registered yet
+	require.NoError(t, w.Poll(ctx))
+	require.True(t, w.Enabled())
+
+	db.switchService("test-watcher", false)
+	require.NoError(t, w.Poll(ctx))
+	require.False(t, w.Enabled())
+	require.Equal(t, 0.0, testutil.ToFloat64(serviceEnabled.WithLabelValues("test-watcher")))
+	require.Equal(t, disabled+1, testutil.ToFloat64(countTransitions.WithLabelValues("test-watcher", stateDisabled)))
+
+	// the state is kept on errors
+	db.mu.Lock()
+	db.err = errors.New("db is down")
+	db.mu.Unlock()
+	require.Error(t, w.Poll(ctx))
+	require.False(t, w.Enabled())
+	db.mu.Lock()
+	db.err = nil
+	db.mu.Unlock()
+
+	// refresh doesn't poll until the interval passes
+	db.switchService("test-watcher", true)
+	calls := db.getCalls()
+	require.False(t, w.Refresh(ctx))
+	require.Equal(t, calls, db.getCalls())
+	time.Sleep(interval)
+	require.True(t, w.Refresh(ctx))
+	require.Equal(t, enabled+1, testutil.ToFloat64(countTransitions.WithLabelValues("test-watcher", stateEnabled)))
+
+	// failed refresh is not retried until the interval passes
+	db.mu.Lock()
+	db.err = errors.New("db is down")
+	db.mu.Unlock()
+	time.Sleep(interval)
+	calls = db.getCalls()
+	require.True(t, w.Refresh(ctx))
+	require.Equal(t, calls+1, db.getCalls())
+	require.True(t, w.Refresh(ctx))
+	require.Equal(t, calls+1, db.getCalls())
+}
+
+func TestWatcherInit(t *testing.T) {
+	db := &stateDatabase{svcs: map[string]bool{}}
+	w := NewWatcher(db, "test-init", time.Hour)
+	disabled := testutil.ToFloat64(countTransitions.WithLabelValues("test-init", stateDisabled))
+
+	// the initial state is not a transition
+	w.init(false)
+	require.False(t, w.Enabled())
+	require.Equal(t, 0.0, testutil.ToFloat64(serviceEnabled.WithLabelValues("test-init")))
+	require.Equal(t, disabled, testutil.ToFloat64(countTransitions.WithLabelValues("test-init", stateDisabled)))
+}
+
+func TestWatcherRun(t *testing.T) {
+	db := &stateDatabase{svcs: map[string]bool{"test-run": false}}
+	w := NewWatcher(db, "test-run", 10*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Run(context.Background())
+	}()
+	require.Eventually(t, func() bool { return !w.Enabled() }, time.Second, time.Millisecond)
+	db.switchService("test-run", true)
+	require.Eventually(t, w.Enabled, time.Second, time.Millisecond)
+	require.NoError(t, w.Close())
+	<-done
+}
+
+func TestWatcherMiddleware(t *testing.T) {
+	ctx := context.Background()
+	db := &stateDatabase{svcs: map[string]bool{}}
+	w := NewWatcher(db, "test-middleware", time.Hour)
+
+	h := w.HTTPMiddleware(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	serve := func() int {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		return rec.Code
+	}
+	handled := 0
+	ph := w.Handler(func(ctx context.Context, msg pubsub.Message) error {
+		handled++
+		return nil
+	})
+
+	require.Equal(t, http.StatusOK, serve())
+	require.NoError(t, ph(ctx, pubsub.Message{}))
+
+	db.switchService("test-middleware", false)
+	require.NoError(t, w.Poll(ctx))
+	require.Equal(t, http.StatusServiceUnavailable, serve())
+	err := ph(ctx, pubsub.Message{})
+	require.ErrorIs(t, err, ErrDisabled)
+	require.Equal(t, 1, handled)
+
+	// disabled messages are nacked
+	sub := pubsub.NewMemSubscriber(1)
+	require.NoError(t, sub.Publish(ctx, []byte("1")))
+	sub.Close()
+	require.NoError(t, sub.Receive(ctx, ph))
+	require.Len(t, sub.GetNacked(), 1)
+}